@@ -31,20 +31,24 @@ func main() {
 	// use middleware
 	quick.Use(
 		func(r *http.Request) {
+			redirectNum, _ := quick.RedirectNumFromContext(r.Context())
+			proxy, _ := quick.ProxyFromContext(r.Context())
 			log.Printf(
 				"Middleware: %v RedirectNum: %v Proxy: %v \n",
 				r.URL,
-				r.Context().Value(quick.ContextRedirectNumKey),
-				r.Context().Value(quick.ContextProxyKey),
+				redirectNum,
+				proxy,
 			)
 		},
 
 		func(r *http.Request) {
+			redirectNum, _ := quick.RedirectNumFromContext(r.Context())
+			proxy, _ := quick.ProxyFromContext(r.Context())
 			log.Printf(
 				"Middleware2: %v RedirectNum: %v Proxy: %v \n",
 				r.URL,
-				r.Context().Value(quick.ContextRedirectNumKey),
-				r.Context().Value(quick.ContextProxyKey),
+				redirectNum,
+				proxy,
 			)
 		},
 	)
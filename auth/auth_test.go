@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/telanflow/quick"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// authServer is an authorization server on /token and a protected API on /api.
+type authServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	grants    []string
+	issued    int
+	expiresIn int
+	valid     map[string]bool // access tokens
+	refresh   map[string]bool // refresh tokens
+}
+
+func runAuthServer() *authServer {
+	s := &authServer{expiresIn: 3600, valid: map[string]bool{}, refresh: map[string]bool{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if r.URL.Path == "/api" {
+			const bearer = "Bearer "
+			auth := r.Header.Get("Authorization")
+			if len(auth) < len(bearer) || !s.valid[auth[len(bearer):]] {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			_, _ = fmt.Fprintf(w, "%s %s", auth[len(bearer):], body)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		grant := r.PostFormValue("grant_type")
+		s.grants = append(s.grants, grant)
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		switch grant {
+		case "client_credentials":
+		case "password":
+			if r.PostFormValue("username") != "user" || r.PostFormValue("password") != "pass" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = fmt.Fprint(w, `{"error":"invalid_grant"}`)
+				return
+			}
+		case "refresh_token":
+			if !s.refresh[r.PostFormValue("refresh_token")] {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = fmt.Fprint(w, `{"error":"invalid_grant","error_description":"revoked"}`)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"error":"unsupported_grant_type"}`)
+			return
+		}
+		s.issued++
+		token := fmt.Sprintf("token-%d", s.issued)
+		refresh := fmt.Sprintf("refresh-%d", s.issued)
+		s.valid[token] = true
+		s.refresh[refresh] = true
+		_, _ = fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","expires_in":%d,"refresh_token":%q}`,
+			token, s.expiresIn, refresh)
+	}))
+	return s
+}
+
+func (s *authServer) config() *Config {
+	return &Config{TokenURL: s.URL + "/token", ClientID: "client", ClientSecret: "secret"}
+}
+
+func (s *authServer) revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.valid = map[string]bool{}
+	s.refresh = map[string]bool{}
+}
+
+func (s *authServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.grants...)
+}
+
+func TestUse(t *testing.T) {
+	asserts := assert.New(t)
+
+	srv := runAuthServer()
+	defer srv.Close()
+
+	session := quick.NewSession()
+	Use(session, srv.config().ClientCredentials())
+
+	// a single token request for concurrent requests
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := session.Get(srv.URL + "/api")
+			if asserts.NoError(err) {
+				asserts.Equal(http.StatusOK, resp.StatusCode)
+				asserts.Equal("token-1 ", resp.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	asserts.Equal([]string{"client_credentials"}, srv.requests())
+
+	// the rejected token is replaced, the revoked refresh token falling back
+	// to the grant, and the request is sent once more with its body
+	srv.revoke()
+	resp, err := session.Post(srv.URL+"/api", quick.OptionBody("payload"))
+	if asserts.NoError(err) {
+		asserts.Equal(http.StatusOK, resp.StatusCode)
+		asserts.Equal("token-2 payload", resp.Body.String())
+	}
+	asserts.Equal([]string{"client_credentials", "refresh_token", "client_credentials"}, srv.requests())
+
+	// bad client credentials
+	config := srv.config()
+	config.ClientSecret = "wrong"
+	_, err = Use(quick.NewSession(), config.ClientCredentials()).Get(srv.URL + "/api")
+	var tokenErr *Error
+	if asserts.True(errors.As(err, &tokenErr)) {
+		asserts.Equal(http.StatusUnauthorized, tokenErr.StatusCode)
+		asserts.Equal("invalid_client", tokenErr.Code)
+	}
+}
+
+func TestUse_Redirect(t *testing.T) {
+	asserts := assert.New(t)
+
+	srv := runAuthServer()
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var received []string
+	record := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r.Host+" "+r.Header.Get("Authorization"))
+	}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+	}))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		if r.URL.Path == "/same" {
+			http.Redirect(w, r, "/other", http.StatusFound)
+		} else if r.URL.Path == "/other" {
+			http.Redirect(w, r, other.URL+"/api", http.StatusFound)
+		}
+	}))
+	defer origin.Close()
+
+	session := Use(quick.NewSession(), srv.config().ClientCredentials())
+	_, err := session.Get(origin.URL + "/same")
+	asserts.NoError(err)
+
+	// the token is only sent to the host of the original request
+	originHost := strings.TrimPrefix(origin.URL, "http://")
+	otherHost := strings.TrimPrefix(other.URL, "http://")
+	asserts.Equal([]string{
+		originHost + " Bearer token-1",
+		originHost + " Bearer token-1",
+		otherHost + " ",
+	}, received)
+}
+
+func TestTokenSource_Refresh(t *testing.T) {
+	asserts := assert.New(t)
+
+	srv := runAuthServer()
+	defer srv.Close()
+	srv.expiresIn = 600
+
+	now := time.Now()
+	source := srv.config().PasswordGrant("user", "pass")
+	source.now = func() time.Time { return now }
+
+	token, err := source.Token(context.Background())
+	asserts.NoError(err)
+	asserts.Equal("token-1", token.AccessToken)
+	asserts.Equal("Bearer", token.Type())
+	asserts.Equal(now.Add(10*time.Minute), token.Expiry)
+
+	// refreshed in the background before the expiry
+	now = now.Add(9*time.Minute + 30*time.Second)
+	token, _ = source.Token(context.Background())
+	asserts.Equal("token-1", token.AccessToken)
+	asserts.Eventually(func() bool {
+		token, _ := source.Token(context.Background())
+		return token.AccessToken == "token-2"
+	}, time.Second, 10*time.Millisecond)
+	asserts.Equal([]string{"password", "refresh_token"}, srv.requests())
+
+	// expired, the rejected refresh token falls back to the password grant
+	srv.revoke()
+	now = now.Add(time.Hour)
+	token, err = source.Token(context.Background())
+	asserts.NoError(err)
+	asserts.Equal("token-3", token.AccessToken)
+	asserts.Equal([]string{"password", "refresh_token", "refresh_token", "password"}, srv.requests())
+
+	// a refresh token only
+	source = srv.config().RefreshToken("refresh-3")
+	token, err = source.Token(context.Background())
+	asserts.NoError(err)
+	asserts.Equal("token-4", token.AccessToken)
+	srv.revoke()
+	source.Invalidate(token)
+	_, err = source.Token(context.Background())
+	var tokenErr *Error
+	if asserts.True(errors.As(err, &tokenErr)) {
+		asserts.Equal("invalid_grant", tokenErr.Code)
+		asserts.Equal("revoked", tokenErr.Description)
+	}
+
+	// a failed background refresh is tried again after a while
+	_, _ = srv.config().ClientCredentials().Token(context.Background())
+	source = srv.config().RefreshToken("refresh-5")
+	source.now = func() time.Time { return now }
+	token, err = source.Token(context.Background())
+	asserts.NoError(err)
+	asserts.Equal("token-6", token.AccessToken)
+	srv.revoke()
+	requests := len(srv.requests())
+	now = now.Add(9*time.Minute + 30*time.Second)
+	_, _ = source.Token(context.Background())
+	asserts.Eventually(func() bool {
+		return len(srv.requests()) == requests+1
+	}, time.Second, 10*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		token, err = source.Token(context.Background())
+		asserts.NoError(err)
+		asserts.Equal("token-6", token.AccessToken)
+	}
+	time.Sleep(20 * time.Millisecond)
+	asserts.Len(srv.requests(), requests+1)
+	now = now.Add(refreshRetryInterval)
+	_, _ = source.Token(context.Background())
+	asserts.Eventually(func() bool {
+		return len(srv.requests()) == requests+2
+	}, time.Second, 10*time.Millisecond)
+
+	// waiting ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = srv.config().ClientCredentials().Token(ctx)
+	asserts.Equal(context.Canceled, err)
+}
@@ -0,0 +1,316 @@
+// Package auth obtains OAuth2 access tokens for quick sessions.
+//
+//	config := &auth.Config{
+//		TokenURL:     "https://example.com/oauth/token",
+//		ClientID:     "id",
+//		ClientSecret: "secret",
+//		Scopes:       []string{"read"},
+//	}
+//	session := quick.NewSession()
+//	auth.Use(session, config.ClientCredentials())
+//	resp, err := session.Get("https://example.com/api/me")
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/telanflow/quick"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoRefreshToken is returned by a refresh token source without a refresh token
+var ErrNoRefreshToken = errors.New("oauth2: no refresh token")
+
+// DefaultRefreshBefore is how long before its expiry a token is refreshed by default
+const DefaultRefreshBefore = time.Minute
+
+// refreshRetryInterval is the wait before another background refresh after a failed one
+const refreshRetryInterval = 10 * time.Second
+
+// Token is an OAuth2 access token
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresIn    int64     `json:"expires_in,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	Expiry       time.Time `json:"-"` // zero when the token does not expire
+
+	refreshAt time.Time // refreshed in the background from then
+}
+
+// Type returns the token type for the Authorization header, "Bearer" by default.
+func (t *Token) Type() string {
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		return "Bearer"
+	}
+	return t.TokenType
+}
+
+// expired reports whether the token is expired at now.
+func (t *Token) expired(now time.Time) bool {
+	return !t.Expiry.IsZero() && !now.Before(t.Expiry)
+}
+
+// Error is an error response of the token endpoint, see RFC 6749 section 5.2
+type Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	URI         string `json:"error_uri,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := "oauth2: token endpoint answered " + strconv.Itoa(e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// Config describes an OAuth2 client and the token endpoint of its
+// authorization server.
+type Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// Params are added to every token request, e.g. an audience.
+	Params url.Values
+
+	// AuthInParams sends the client credentials as form parameters
+	// instead of HTTP Basic authentication.
+	AuthInParams bool
+
+	// RefreshBefore is how long before its expiry a token is refreshed
+	// in the background, DefaultRefreshBefore when 0.
+	RefreshBefore time.Duration
+
+	// Session sends the token requests, a new session when nil. It must not
+	// be a session using a token of this config.
+	Session *quick.Session
+}
+
+// ClientCredentials returns a token source using the client credentials grant.
+func (c *Config) ClientCredentials() *TokenSource {
+	return c.newTokenSource(url.Values{"grant_type": {"client_credentials"}})
+}
+
+// PasswordGrant returns a token source using the resource owner password
+// credentials grant. The refresh token is used while the server accepts it.
+func (c *Config) PasswordGrant(username, password string) *TokenSource {
+	return c.newTokenSource(url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+	})
+}
+
+// RefreshToken returns a token source using a refresh token obtained
+// beforehand, replaced when the server issues a new one.
+func (c *Config) RefreshToken(refreshToken string) *TokenSource {
+	s := c.newTokenSource(nil)
+	s.refreshToken = refreshToken
+	return s
+}
+
+func (c *Config) newTokenSource(grant url.Values) *TokenSource {
+	session := c.Session
+	if session == nil {
+		session = quick.NewSession()
+	}
+	refreshBefore := c.RefreshBefore
+	if refreshBefore <= 0 {
+		refreshBefore = DefaultRefreshBefore
+	}
+	return &TokenSource{
+		config:        c,
+		session:       session,
+		grant:         grant,
+		refreshBefore: refreshBefore,
+		now:           time.Now,
+	}
+}
+
+// TokenSource caches the token of a grant until it expires. The token is
+// refreshed in the background from RefreshBefore its expiry, every few
+// seconds while the refresh fails, and a single token request is in flight
+// at a time. It is safe for concurrent use.
+type TokenSource struct {
+	config        *Config
+	session       *quick.Session
+	grant         url.Values // nil for a refresh token only
+	refreshBefore time.Duration
+	now           func() time.Time
+
+	mu           sync.Mutex
+	token        *Token
+	refreshToken string
+	call         *tokenCall
+}
+
+// tokenCall is an in-flight token request
+type tokenCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// Token returns a valid token, requesting one from the token endpoint
+// when there is none. Waiting for it ends with ctx.
+func (s *TokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	now := s.now()
+	if token := s.token; token != nil && !token.expired(now) {
+		// refresh proactively, the current token is still good meanwhile
+		if !token.refreshAt.IsZero() && !now.Before(token.refreshAt) && s.call == nil {
+			s.fetch()
+		}
+		s.mu.Unlock()
+		return token, nil
+	}
+	call := s.call
+	if call == nil {
+		call = s.fetch()
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate drops token when it is the cached one, e.g. after the server
+// rejected it. The next call of Token requests a new one.
+func (s *TokenSource) Invalidate(token *Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = nil
+	}
+}
+
+// fetch starts a token request, s.mu must be held. The request is not
+// bound to a caller context, so that one caller giving up does not fail
+// the others; the session request timeout applies.
+func (s *TokenSource) fetch() *tokenCall {
+	call := &tokenCall{done: make(chan struct{})}
+	s.call = call
+	refreshToken := s.refreshToken
+
+	go func() {
+		token, err := s.request(refreshToken)
+
+		s.mu.Lock()
+		if err == nil {
+			s.token = token
+			s.refreshToken = token.RefreshToken
+		} else if s.token != nil {
+			// the current token is kept, the refresh is tried again later
+			s.token.refreshAt = s.now().Add(refreshRetryInterval)
+		}
+		s.call = nil
+		s.mu.Unlock()
+
+		call.token, call.err = token, err
+		close(call.done)
+	}()
+	return call
+}
+
+// request obtains a token with the refresh token when there is one,
+// falling back to the grant of the source when the server rejects it.
+func (s *TokenSource) request(refreshToken string) (*Token, error) {
+	if refreshToken != "" {
+		token, err := s.post(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+		})
+		if err == nil {
+			// the refresh token is kept unless a new one is issued
+			if token.RefreshToken == "" {
+				token.RefreshToken = refreshToken
+			}
+			return token, nil
+		}
+		if _, rejected := err.(*Error); !rejected || s.grant == nil {
+			return nil, err
+		}
+	}
+	if s.grant == nil {
+		return nil, ErrNoRefreshToken
+	}
+	return s.post(s.grant)
+}
+
+// post sends a token request to the token endpoint.
+func (s *TokenSource) post(grant url.Values) (*Token, error) {
+	c := s.config
+	form := url.Values{}
+	for key, values := range c.Params {
+		form[key] = append([]string(nil), values...)
+	}
+	for key, values := range grant {
+		form[key] = values
+	}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+
+	ops := []quick.OptionFunc{
+		quick.OptionHeaderSingle("Accept", "application/json"),
+	}
+	if c.AuthInParams {
+		form.Set("client_id", c.ClientID)
+		if c.ClientSecret != "" {
+			form.Set("client_secret", c.ClientSecret)
+		}
+	} else {
+		// RFC 6749 section 2.3.1, the credentials are form encoded first
+		ops = append(ops, quick.OptionBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret)))
+	}
+	ops = append(ops, quick.OptionBodyXWwwFormUrlencoded(form))
+
+	start := s.now()
+	resp, err := s.session.Post(c.TokenURL, ops...)
+	if err != nil {
+		return nil, quick.WrapErr(err, "oauth2: token request failed")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		tokenErr := &Error{}
+		_ = resp.GetJson(tokenErr)
+		tokenErr.StatusCode = resp.StatusCode
+		return nil, tokenErr
+	}
+
+	token := &Token{}
+	if err := resp.GetJson(token); err != nil {
+		return nil, quick.WrapErr(err, "oauth2: malformed token response")
+	}
+	if token.AccessToken == "" {
+		return nil, &Error{StatusCode: resp.StatusCode, Description: "no access token in response"}
+	}
+	if token.ExpiresIn > 0 {
+		// from the time of the request, the server may have issued the token right away
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		token.Expiry = start.Add(lifetime)
+		// short-lived tokens are refreshed halfway through
+		refreshBefore := s.refreshBefore
+		if refreshBefore > lifetime/2 {
+			refreshBefore = lifetime / 2
+		}
+		token.refreshAt = token.Expiry.Add(-refreshBefore)
+	}
+	return token, nil
+}
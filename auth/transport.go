@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"github.com/telanflow/quick"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Transport is an http.RoundTripper authorizing requests with the tokens
+// of Source. When the server answers 401 Unauthorized, the token is
+// invalidated and the request is sent once more with a new token, provided
+// its body can be replayed.
+type Transport struct {
+	Source *TokenSource
+	// Base sends the requests, http.DefaultTransport when nil.
+	Base http.RoundTripper
+}
+
+// Use authorizes the requests of session with the tokens of source, wrapping
+// the session transport so that the session settings keep applying.
+// Redirects to another host or scheme are sent without the token.
+func Use(session *quick.Session, source *TokenSource) *quick.Session {
+	return session.SetTransport(&Transport{
+		Source: source,
+		Base:   session.GetTransport(),
+	})
+}
+
+// RoundTrip implements http.RoundTripper.
+// Only the requests to the origin of the original request are authorized,
+// a redirect to another host or scheme is sent as is.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !sameOrigin(req.URL, originalRequest(req).URL) {
+		return t.base().RoundTrip(req)
+	}
+	token, err := t.Source.Token(req.Context())
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	resp, err := t.base().RoundTrip(authorize(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// retry once with a new token, the 401 is returned when that is not possible
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	t.Source.Invalidate(token)
+	retryToken, err := t.Source.Token(req.Context())
+	if err != nil || retryToken.AccessToken == token.AccessToken {
+		return resp, nil
+	}
+	retry := authorize(req, retryToken)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+	_ = resp.Body.Close()
+	return t.base().RoundTrip(retry)
+}
+
+// Unwrap returns the RoundTripper sending the requests.
+func (t *Transport) Unwrap() http.RoundTripper {
+	return t.base()
+}
+
+// WithBase returns a copy of t sending the requests with base.
+func (t *Transport) WithBase(base http.RoundTripper) http.RoundTripper {
+	return &Transport{Source: t.Source, Base: base}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// originalRequest returns the first request of the redirects leading to req.
+func originalRequest(req *http.Request) *http.Request {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req
+}
+
+// sameOrigin reports whether u and v have the same scheme, host and port.
+func sameOrigin(u, v *url.URL) bool {
+	return strings.EqualFold(u.Scheme, v.Scheme) && strings.EqualFold(u.Host, v.Host)
+}
+
+// authorize returns a copy of req with the Authorization header of token,
+// a RoundTripper must not modify the request.
+func authorize(req *http.Request, token *Token) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", token.Type()+" "+token.AccessToken)
+	return r
+}
+
+// closeBody closes the request body, as a RoundTripper must even on errors.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
@@ -0,0 +1,135 @@
+package quick
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchResult is the result of a request of a batch.
+type BatchResult struct {
+	Index    int // index of the request in the batch
+	Request  *Request
+	Response *Response
+	Err      error
+}
+
+// SuckAll sends reqs with at most concurrency requests at a time, and returns
+// their results in the order of reqs. A failed request does not stop the
+// others, its error is in its result. concurrency <= 0 sends all requests at once.
+//
+// The requests are sent with ctx, or their own context cancelled with ctx.
+// Requests not sent before ctx is done fail with its error.
+func (session *Session) SuckAll(ctx context.Context, reqs []*Request, concurrency int, ops ...OptionFunc) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	session.suckAll(ctx, reqs, concurrency, false, ops, func(result BatchResult) {
+		results[result.Index] = result
+	})
+	return results
+}
+
+// SuckAllFailFast is like SuckAll, but the first failed request cancels the
+// others. It returns the results in the order of reqs and the first error.
+func (session *Session) SuckAllFailFast(ctx context.Context, reqs []*Request, concurrency int, ops ...OptionFunc) ([]BatchResult, error) {
+	var (
+		results  = make([]BatchResult, len(reqs))
+		firstErr error
+	)
+	session.suckAll(ctx, reqs, concurrency, true, ops, func(result BatchResult) {
+		results[result.Index] = result
+		if result.Err != nil && firstErr == nil {
+			firstErr = result.Err
+		}
+	})
+	return results, firstErr
+}
+
+// SuckAllStream is like SuckAll, but delivers the results on the returned
+// channel as the requests complete. The channel is closed after the last one.
+func (session *Session) SuckAllStream(ctx context.Context, reqs []*Request, concurrency int, ops ...OptionFunc) <-chan BatchResult {
+	ch := make(chan BatchResult, len(reqs))
+	go func() {
+		defer close(ch)
+		session.suckAll(ctx, reqs, concurrency, false, ops, func(result BatchResult) {
+			ch <- result
+		})
+	}()
+	return ch
+}
+
+// suckAll sends reqs with a pool of concurrency workers, calling emit with
+// every result. emit calls are serialized. With failFast, the first error
+// cancels the requests left.
+func (session *Session) suckAll(ctx context.Context, reqs []*Request, concurrency int, failFast bool, ops []OptionFunc, emit func(BatchResult)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if concurrency <= 0 || concurrency > len(reqs) {
+		concurrency = len(reqs)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		indexes = make(chan int)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				result := session.suckOne(ctx, index, reqs[index], ops)
+
+				mu.Lock()
+				emit(result)
+				mu.Unlock()
+				if failFast && result.Err != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for i := range reqs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// suckOne sends a request of a batch. req itself is left untouched by the batch context.
+func (session *Session) suckOne(ctx context.Context, index int, req *Request, ops []OptionFunc) BatchResult {
+	result := BatchResult{Index: index, Request: req}
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	reqCtx, cancel := mergeContext(ctx, req.ctx)
+	defer cancel()
+
+	// a copy, the options and the send modify the request
+	r := req.Copy()
+	r.ctx = reqCtx
+	result.Response, result.Err = session.Suck(r, ops...)
+	return result
+}
+
+// mergeContext returns a copy of parent also cancelled when ctx is done.
+// parent may be nil.
+func mergeContext(ctx, parent context.Context) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		return context.WithCancel(ctx)
+	}
+	merged, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-merged.Done():
+		}
+	}()
+	return merged, cancel
+}
@@ -0,0 +1,140 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// RunBatchServer answers /<n> with n after n milliseconds, and fails /fail.
+func RunBatchServer(inFlight, maxInFlight *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(inFlight, 1)
+		defer atomic.AddInt32(inFlight, -1)
+		for {
+			max := atomic.LoadInt32(maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(maxInFlight, max, n) {
+				break
+			}
+		}
+
+		ms, err := strconv.Atoi(r.URL.Path[1:])
+		if err != nil {
+			hj, _ := w.(http.Hijacker)
+			conn, _, _ := hj.Hijack()
+			_ = conn.Close()
+			return
+		}
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		_, _ = w.Write([]byte(r.URL.Path[1:]))
+	}))
+}
+
+func TestSession_SuckAll(t *testing.T) {
+	asserts := assert.New(t)
+
+	var inFlight, maxInFlight int32
+	ser := RunBatchServer(&inFlight, &maxInFlight)
+	defer ser.Close()
+
+	paths := []string{"30", "10", "fail", "20", "0"}
+	reqs := make([]*Request, len(paths))
+	for i, path := range paths {
+		reqs[i] = NewRequest().SetUrl(ser.URL + "/" + path)
+	}
+
+	results := NewSession().SuckAll(context.Background(), reqs, 2)
+	asserts.Len(results, len(reqs))
+	for i, result := range results {
+		asserts.Equal(result.Index, i)
+		asserts.Equal(result.Request, reqs[i])
+		if paths[i] == "fail" {
+			asserts.Error(result.Err)
+			continue
+		}
+		if asserts.NoError(result.Err) {
+			asserts.Equal(result.Response.Body.String(), paths[i])
+		}
+	}
+	asserts.Equal(atomic.LoadInt32(&maxInFlight), int32(2))
+	asserts.Equal(reqs[0].GetUrl(), ser.URL+"/30")
+}
+
+func TestSession_SuckAll_SharedRequest(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(append([]byte(r.Header.Get("X-Batch")+" "), body...))
+	}))
+	defer ser.Close()
+
+	// the same request sent concurrently, with options modifying it
+	req := NewRequest().SetUrl(ser.URL).SetMethod(http.MethodPost)
+	req.Body = strings.NewReader("quick")
+	reqs := []*Request{req, req, req, req}
+	results := NewSession().SuckAll(context.Background(), reqs, 0, OptionHeaderSingle("X-Batch", "batch"))
+	for _, result := range results {
+		if asserts.NoError(result.Err) {
+			asserts.Equal("batch quick", result.Response.Body.String())
+		}
+	}
+	asserts.Empty(req.Header.Get("X-Batch"))
+	body, _ := ioutil.ReadAll(req.Body)
+	asserts.Equal("quick", string(body))
+}
+
+func TestSession_SuckAllFailFast(t *testing.T) {
+	asserts := assert.New(t)
+
+	var inFlight, maxInFlight int32
+	ser := RunBatchServer(&inFlight, &maxInFlight)
+	defer ser.Close()
+
+	reqs := []*Request{
+		NewRequest().SetUrl(ser.URL + "/fail"),
+		NewRequest().SetUrl(ser.URL + "/1000"),
+		NewRequest().SetUrl(ser.URL + "/0"),
+	}
+	start := time.Now()
+	results, err := NewSession().SuckAllFailFast(context.Background(), reqs, 2)
+	asserts.Error(err)
+	asserts.Equal(err, results[0].Err)
+	asserts.Error(results[1].Err) // cancelled in flight
+	asserts.True(errors.Is(results[2].Err, context.Canceled))
+	asserts.True(time.Since(start) < time.Second)
+}
+
+func TestSession_SuckAllStream(t *testing.T) {
+	asserts := assert.New(t)
+
+	var inFlight, maxInFlight int32
+	ser := RunBatchServer(&inFlight, &maxInFlight)
+	defer ser.Close()
+
+	reqs := []*Request{
+		NewRequest().SetUrl(ser.URL + "/100"),
+		NewRequest().SetUrl(ser.URL + "/0"),
+	}
+	order := make([]int, 0)
+	for result := range NewSession().SuckAllStream(context.Background(), reqs, 0) {
+		asserts.NoError(result.Err)
+		order = append(order, result.Index)
+	}
+	asserts.Equal(order, []int{1, 0})
+
+	// the batch context cancels the request contexts
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	reqCtx := context.WithValue(context.Background(), contextKey{"test"}, 1)
+	results := NewSession().SuckAll(ctx, []*Request{NewRequestWithContext(reqCtx).SetUrl(ser.URL + "/1000")}, 1)
+	asserts.Error(results[0].Err)
+}
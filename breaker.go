@@ -0,0 +1,294 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for a request to a host whose circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of the circuit of a host.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through, counting their failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests immediately with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial requests through,
+	// closing the circuit if they succeed.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker stops sending requests to failing hosts.
+//
+// The circuit of a host opens when the ratio of failed requests over Window
+// reaches FailureRatio, after at least MinRequests requests. Requests then
+// fail with ErrCircuitOpen until CoolDown has elapsed, when HalfOpenRequests
+// trial requests are let through: the circuit closes if they all succeed,
+// and opens again otherwise. The zero value, and zero fields, use the
+// default settings.
+//
+//	cb := quick.NewCircuitBreaker()
+//	cb.OnStateChange = func(host string, from, to quick.CircuitState) {
+//		log.Printf("circuit %s: %s -> %s", host, from, to)
+//	}
+//	session := quick.NewSession().SetCircuitBreaker(cb)
+type CircuitBreaker struct {
+	// FailureRatio is the ratio of failed requests opening the circuit. Default 0.5.
+	FailureRatio float64
+
+	// MinRequests is the number of requests over Window before the
+	// failure ratio applies. Default 10.
+	MinRequests int
+
+	// Window is the period requests are counted over. Default 60s.
+	Window time.Duration
+
+	// CoolDown is how long the circuit stays open. Default 30s.
+	CoolDown time.Duration
+
+	// HalfOpenRequests is the number of trial requests of a half-open circuit. Default 1.
+	HalfOpenRequests int
+
+	// IsFailure reports whether a request failed. By default,
+	// errors and 5xx responses are failures. Requests cancelled
+	// by their caller are not counted.
+	IsFailure func(resp *http.Response, err error) bool
+
+	// OnStateChange, if set, is called when the circuit of a host changes state.
+	OnStateChange func(host string, from, to CircuitState)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+	now   func() time.Time
+}
+
+type circuit struct {
+	state       CircuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	trials      int // requests let through while half-open
+	successes   int // successful trials
+}
+
+// Default circuit breaker settings
+const (
+	defaultFailureRatio     = 0.5
+	defaultMinRequests      = 10
+	defaultWindow           = 60 * time.Second
+	defaultCoolDown         = 30 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+// NewCircuitBreaker create a circuit breaker with the default settings.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureRatio:     defaultFailureRatio,
+		MinRequests:      defaultMinRequests,
+		Window:           defaultWindow,
+		CoolDown:         defaultCoolDown,
+		HalfOpenRequests: defaultHalfOpenRequests,
+		hosts:            make(map[string]*circuit),
+		now:              time.Now,
+	}
+}
+
+// State returns the state of the circuit of host.
+func (cb *CircuitBreaker) State(host string) CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if c, ok := cb.hosts[host]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// Reset closes the circuit of host.
+func (cb *CircuitBreaker) Reset(host string) {
+	cb.mu.Lock()
+	c, ok := cb.hosts[host]
+	delete(cb.hosts, host)
+	cb.mu.Unlock()
+
+	if ok && c.state != CircuitClosed {
+		cb.notify(host, c.state, CircuitClosed)
+	}
+}
+
+// allow reports whether a request to host may be sent.
+func (cb *CircuitBreaker) allow(host string) error {
+	cb.mu.Lock()
+	c := cb.circuit(host)
+	from := c.state
+	if c.state == CircuitOpen && cb.now().Sub(c.openedAt) >= cb.coolDown() {
+		c.state = CircuitHalfOpen
+		c.trials = 0
+		c.successes = 0
+	}
+
+	var err error
+	switch c.state {
+	case CircuitOpen:
+		err = WrapErrf(ErrCircuitOpen, "CircuitBreaker: %s", host)
+	case CircuitHalfOpen:
+		if c.trials >= cb.halfOpenRequests() {
+			err = WrapErrf(ErrCircuitOpen, "CircuitBreaker: %s", host)
+		} else {
+			c.trials++
+		}
+	}
+	to := c.state
+	cb.mu.Unlock()
+
+	if from != to {
+		cb.notify(host, from, to)
+	}
+	return err
+}
+
+// cancel gives back a request allowed to host that was not sent.
+func (cb *CircuitBreaker) cancel(host string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.circuit(host).cancel()
+}
+
+// report records the outcome of a request to host.
+func (cb *CircuitBreaker) report(host string, resp *http.Response, err error) {
+	cb.mu.Lock()
+	c := cb.circuit(host)
+	if errors.Is(err, context.Canceled) {
+		c.cancel()
+		cb.mu.Unlock()
+		return
+	}
+
+	failure := cb.isFailure(resp, err)
+	from := c.state
+	now := cb.now()
+	switch c.state {
+	case CircuitClosed:
+		if now.Sub(c.windowStart) >= cb.window() {
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+		c.requests++
+		if failure {
+			c.failures++
+		}
+		if c.requests >= cb.minRequests() && float64(c.failures) >= cb.failureRatio()*float64(c.requests) {
+			c.state = CircuitOpen
+			c.openedAt = now
+		}
+	case CircuitHalfOpen:
+		if failure {
+			c.state = CircuitOpen
+			c.openedAt = now
+		} else if c.successes++; c.successes >= cb.halfOpenRequests() {
+			c.state = CircuitClosed
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+	}
+	to := c.state
+	cb.mu.Unlock()
+
+	if from != to {
+		cb.notify(host, from, to)
+	}
+}
+
+// cancel gives the trial of a request not sent back.
+func (c *circuit) cancel() {
+	if c.state == CircuitHalfOpen && c.trials > 0 {
+		c.trials--
+	}
+}
+
+// circuit returns the circuit of host, cb.mu must be held.
+func (cb *CircuitBreaker) circuit(host string) *circuit {
+	if cb.hosts == nil {
+		cb.hosts = make(map[string]*circuit)
+	}
+	if cb.now == nil {
+		cb.now = time.Now
+	}
+	c, ok := cb.hosts[host]
+	if !ok {
+		c = &circuit{windowStart: cb.now()}
+		cb.hosts[host] = c
+	}
+	return c
+}
+
+func (cb *CircuitBreaker) failureRatio() float64 {
+	if cb.FailureRatio <= 0 {
+		return defaultFailureRatio
+	}
+	return cb.FailureRatio
+}
+
+func (cb *CircuitBreaker) minRequests() int {
+	if cb.MinRequests < 1 {
+		return defaultMinRequests
+	}
+	return cb.MinRequests
+}
+
+func (cb *CircuitBreaker) window() time.Duration {
+	if cb.Window <= 0 {
+		return defaultWindow
+	}
+	return cb.Window
+}
+
+func (cb *CircuitBreaker) coolDown() time.Duration {
+	if cb.CoolDown <= 0 {
+		return defaultCoolDown
+	}
+	return cb.CoolDown
+}
+
+func (cb *CircuitBreaker) halfOpenRequests() int {
+	if cb.HalfOpenRequests < 1 {
+		return defaultHalfOpenRequests
+	}
+	return cb.HalfOpenRequests
+}
+
+func (cb *CircuitBreaker) isFailure(resp *http.Response, err error) bool {
+	if cb.IsFailure != nil {
+		return cb.IsFailure(resp, err)
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+func (cb *CircuitBreaker) notify(host string, from, to CircuitState) {
+	if cb.OnStateChange != nil {
+		cb.OnStateChange(host, from, to)
+	}
+}
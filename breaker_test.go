@@ -0,0 +1,139 @@
+package quick
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_States(t *testing.T) {
+	asserts := assert.New(t)
+
+	now := time.Now()
+	cb := NewCircuitBreaker()
+	cb.now = func() time.Time { return now }
+	cb.MinRequests = 4
+	cb.HalfOpenRequests = 2
+
+	var changes []string
+	cb.OnStateChange = func(host string, from, to CircuitState) {
+		changes = append(changes, host+" "+from.String()+"->"+to.String())
+	}
+
+	ok := &http.Response{StatusCode: http.StatusOK}
+	fail := &http.Response{StatusCode: http.StatusBadGateway}
+	for _, resp := range []*http.Response{ok, fail, ok} {
+		asserts.NoError(cb.allow("a"))
+		cb.report("a", resp, nil)
+	}
+	asserts.Equal(cb.State("a"), CircuitClosed)
+	asserts.NoError(cb.allow("a"))
+	cb.report("a", nil, errors.New("connection refused"))
+	asserts.Equal(cb.State("a"), CircuitOpen)
+	asserts.True(errors.Is(cb.allow("a"), ErrCircuitOpen))
+	asserts.NoError(cb.allow("b"))
+
+	// half-open after the cool down, with two trials
+	now = now.Add(cb.CoolDown)
+	asserts.NoError(cb.allow("a"))
+	asserts.Equal(cb.State("a"), CircuitHalfOpen)
+	asserts.NoError(cb.allow("a"))
+	asserts.True(errors.Is(cb.allow("a"), ErrCircuitOpen))
+	cb.report("a", ok, nil)
+	cb.report("a", fail, nil)
+	asserts.Equal(cb.State("a"), CircuitOpen)
+
+	now = now.Add(cb.CoolDown)
+	for i := 0; i < 2; i++ {
+		asserts.NoError(cb.allow("a"))
+		cb.report("a", ok, nil)
+	}
+	asserts.Equal(cb.State("a"), CircuitClosed)
+
+	asserts.Equal(changes, []string{
+		"a closed->open",
+		"a open->half-open",
+		"a half-open->open",
+		"a open->half-open",
+		"a half-open->closed",
+	})
+}
+
+func TestCircuitBreaker_ZeroValue(t *testing.T) {
+	asserts := assert.New(t)
+
+	now := time.Now()
+	cb := &CircuitBreaker{now: func() time.Time { return now }}
+	ok := &http.Response{StatusCode: http.StatusOK}
+	fail := &http.Response{StatusCode: http.StatusBadGateway}
+	for i := 0; i < 9; i++ {
+		asserts.NoError(cb.allow("a"))
+		cb.report("a", []*http.Response{ok, fail}[i%2], nil)
+	}
+	asserts.Equal(CircuitClosed, cb.State("a"))
+	asserts.NoError(cb.allow("a"))
+	cb.report("a", fail, nil)
+	asserts.Equal(CircuitOpen, cb.State("a"))
+
+	now = now.Add(defaultCoolDown - time.Second)
+	asserts.True(errors.Is(cb.allow("a"), ErrCircuitOpen))
+	now = now.Add(time.Second)
+	asserts.NoError(cb.allow("a"))
+	asserts.Equal(CircuitHalfOpen, cb.State("a"))
+}
+
+func TestSession_SetCircuitBreaker(t *testing.T) {
+	asserts := assert.New(t)
+
+	var calls int32
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ser.Close()
+
+	cb := NewCircuitBreaker()
+	cb.MinRequests = 3
+	session := NewSession().SetCircuitBreaker(cb)
+	for i := 0; i < 3; i++ {
+		resp, err := session.Get(ser.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asserts.Equal(resp.StatusCode, http.StatusInternalServerError)
+	}
+
+	_, err := session.Get(ser.URL)
+	asserts.True(errors.Is(err, ErrCircuitOpen))
+	asserts.Equal(atomic.LoadInt32(&calls), int32(3))
+
+	// an open circuit fails before the rate limit and the bulkhead
+	session.SetRateLimit(1, 1).SetBulkhead(NewBulkhead(1, 1))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err = session.Get(ser.URL)
+		asserts.True(errors.Is(err, ErrCircuitOpen))
+	}
+	asserts.True(time.Since(start) < 500*time.Millisecond)
+
+	cb.Reset(ser.Listener.Addr().String())
+	_, err = session.Get(ser.URL)
+	asserts.NoError(err)
+
+	// failing to resolve the proxy is not a host failure
+	session = NewSession().SetCircuitBreaker(cb).SetProxyHandler(func(*http.Request) (*url.URL, error) {
+		return nil, errors.New("no proxy")
+	})
+	for i := 0; i < 3; i++ {
+		_, err = session.Get(ser.URL)
+		var proxyErr *ProxyError
+		asserts.True(errors.As(err, &proxyErr))
+	}
+	asserts.Equal(CircuitClosed, cb.State(ser.Listener.Addr().String()))
+	asserts.Equal(atomic.LoadInt32(&calls), int32(4))
+}
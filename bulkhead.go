@@ -0,0 +1,227 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrBulkheadFull is returned when the queue of a Bulkhead is full.
+	ErrBulkheadFull = errors.New("bulkhead queue is full")
+	// ErrBulkheadTimeout is returned when a request waited QueueTimeout for a Bulkhead slot.
+	ErrBulkheadTimeout = errors.New("bulkhead queue timeout")
+)
+
+// BulkheadStats reports the requests of a Bulkhead.
+type BulkheadStats struct {
+	InFlight int64 // requests sent, until their response body is closed
+	Queued   int64 // requests waiting for a slot
+	Rejected int64 // requests refused with ErrBulkheadFull or ErrBulkheadTimeout
+}
+
+// Bulkhead limits the requests a session has in flight, globally and per host.
+//
+// A request holds its slot until its response body is closed. Requests over
+// the limits wait for a slot, up to QueueTimeout or their context deadline.
+// The limits must not be changed once the Bulkhead is in use.
+//
+//	session := quick.NewSession().SetBulkhead(quick.NewBulkhead(100, 10))
+type Bulkhead struct {
+	// MaxInFlight limits the requests in flight. Zero means no limit.
+	MaxInFlight int
+
+	// MaxInFlightPerHost limits the requests in flight to a host. Zero means no limit.
+	MaxInFlightPerHost int
+
+	// MaxQueue limits the requests waiting for a slot, others fail
+	// with ErrBulkheadFull. Zero means no limit.
+	MaxQueue int
+
+	// QueueTimeout limits how long a request waits for a slot, it then fails
+	// with ErrBulkheadTimeout. Zero waits until the request context is done.
+	QueueTimeout time.Duration
+
+	once   sync.Once
+	mu     sync.Mutex
+	global *bulkheadSlots
+	hosts  map[string]*bulkheadSlots
+}
+
+// NewBulkhead create a bulkhead limiting the requests in flight,
+// and the requests in flight per host. Zero means no limit.
+func NewBulkhead(maxInFlight, maxInFlightPerHost int) *Bulkhead {
+	return &Bulkhead{
+		MaxInFlight:        maxInFlight,
+		MaxInFlightPerHost: maxInFlightPerHost,
+	}
+}
+
+// Stats returns the requests of the bulkhead.
+func (b *Bulkhead) Stats() BulkheadStats {
+	b.init()
+	return b.global.stats()
+}
+
+// HostStats returns the requests of the bulkhead to host. The counts of a
+// host are dropped once it has no request in flight or queued.
+func (b *Bulkhead) HostStats(host string) BulkheadStats {
+	b.init()
+	b.mu.Lock()
+	slots, ok := b.hosts[host]
+	b.mu.Unlock()
+	if !ok {
+		return BulkheadStats{}
+	}
+	return slots.stats()
+}
+
+func (b *Bulkhead) init() {
+	b.once.Do(func() {
+		b.global = newBulkheadSlots(b.MaxInFlight)
+		b.hosts = make(map[string]*bulkheadSlots)
+	})
+}
+
+// acquire waits for a slot for a request to host. release frees it.
+func (b *Bulkhead) acquire(ctx context.Context, host string) (release func(), err error) {
+	b.init()
+	b.mu.Lock()
+	hostSlots, ok := b.hosts[host]
+	if !ok {
+		hostSlots = newBulkheadSlots(b.MaxInFlightPerHost)
+		b.hosts[host] = hostSlots
+	}
+	hostSlots.users++
+	b.mu.Unlock()
+
+	if b.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.QueueTimeout)
+		defer cancel()
+	}
+
+	// the host slot first, so queued requests to a busy host
+	// do not hold global slots
+	if err := hostSlots.acquire(ctx, b.MaxQueue); err != nil {
+		b.global.reject()
+		b.leave(host, hostSlots)
+		return nil, b.queueErr(err)
+	}
+	if err := b.global.acquire(ctx, b.MaxQueue); err != nil {
+		hostSlots.release()
+		b.leave(host, hostSlots)
+		return nil, b.queueErr(err)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.global.release()
+			hostSlots.release()
+			b.leave(host, hostSlots)
+		})
+	}, nil
+}
+
+// leave drops the slots of host once no request uses them,
+// so that the hosts map does not grow with every host ever requested.
+func (b *Bulkhead) leave(host string, hostSlots *bulkheadSlots) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if hostSlots.users--; hostSlots.users == 0 && b.hosts[host] == hostSlots {
+		delete(b.hosts, host)
+	}
+}
+
+// queueErr returns ErrBulkheadTimeout when the queue timeout elapsed.
+func (b *Bulkhead) queueErr(err error) error {
+	if err == context.DeadlineExceeded && b.QueueTimeout > 0 {
+		return WrapErrf(ErrBulkheadTimeout, "Bulkhead: waited %s", b.QueueTimeout)
+	}
+	return err
+}
+
+// bulkheadSlots is a semaphore counting its requests.
+type bulkheadSlots struct {
+	inFlight int64
+	queued   int64
+	rejected int64
+	slots    chan struct{} // nil for no limit
+
+	users int // requests in flight or queued, Bulkhead.mu must be held
+}
+
+func newBulkheadSlots(n int) *bulkheadSlots {
+	s := &bulkheadSlots{}
+	if n > 0 {
+		s.slots = make(chan struct{}, n)
+	}
+	return s
+}
+
+func (s *bulkheadSlots) acquire(ctx context.Context, maxQueue int) error {
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			if queued := atomic.AddInt64(&s.queued, 1); maxQueue > 0 && queued > int64(maxQueue) {
+				atomic.AddInt64(&s.queued, -1)
+				s.reject()
+				return WrapErr(ErrBulkheadFull, "Bulkhead")
+			}
+			defer atomic.AddInt64(&s.queued, -1)
+
+			select {
+			case s.slots <- struct{}{}:
+			case <-ctx.Done():
+				s.reject()
+				return ctx.Err()
+			}
+		}
+	}
+	atomic.AddInt64(&s.inFlight, 1)
+	return nil
+}
+
+func (s *bulkheadSlots) release() {
+	atomic.AddInt64(&s.inFlight, -1)
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+func (s *bulkheadSlots) reject() {
+	atomic.AddInt64(&s.rejected, 1)
+}
+
+func (s *bulkheadSlots) stats() BulkheadStats {
+	return BulkheadStats{
+		InFlight: atomic.LoadInt64(&s.inFlight),
+		Queued:   atomic.LoadInt64(&s.queued),
+		Rejected: atomic.LoadInt64(&s.rejected),
+	}
+}
+
+// releaseBody releases the bulkhead slot of a response once its body is closed or read.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
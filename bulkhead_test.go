@@ -0,0 +1,115 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBulkhead_Acquire(t *testing.T) {
+	asserts := assert.New(t)
+
+	b := NewBulkhead(2, 1)
+	b.QueueTimeout = 20 * time.Millisecond
+	ctx := context.Background()
+
+	releaseA, err := b.acquire(ctx, "a")
+	asserts.NoError(err)
+	releaseB, err := b.acquire(ctx, "b")
+	asserts.NoError(err)
+	asserts.Equal(b.Stats().InFlight, int64(2))
+	asserts.Equal(b.HostStats("a").InFlight, int64(1))
+
+	// per host limit
+	_, err = b.acquire(ctx, "a")
+	asserts.True(errors.Is(err, ErrBulkheadTimeout))
+	// global limit
+	_, err = b.acquire(ctx, "c")
+	asserts.True(errors.Is(err, ErrBulkheadTimeout))
+	asserts.Equal(b.Stats().Rejected, int64(2))
+
+	// context cancellation
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = b.acquire(cctx, "c")
+	asserts.Equal(err, context.Canceled)
+
+	// a waiting request gets the released slot
+	done := make(chan error)
+	go func() {
+		release, err := b.acquire(ctx, "c")
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	releaseA()
+	releaseA() // released once
+	asserts.NoError(<-done)
+	releaseB()
+	asserts.Equal(b.Stats().InFlight, int64(0))
+
+	// idle hosts are dropped
+	b.mu.Lock()
+	asserts.Empty(b.hosts)
+	b.mu.Unlock()
+	asserts.Equal(BulkheadStats{}, b.HostStats("a"))
+
+	// queue length
+	b = NewBulkhead(1, 0)
+	b.MaxQueue = 1
+	release, _ := b.acquire(ctx, "a")
+	go func() {
+		_, _ = b.acquire(ctx, "a")
+	}()
+	for b.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err = b.acquire(ctx, "a")
+	asserts.True(errors.Is(err, ErrBulkheadFull))
+	release()
+}
+
+func TestSession_SetBulkhead(t *testing.T) {
+	asserts := assert.New(t)
+
+	var inFlight, maxInFlight int32
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		_, _ = w.Write([]byte("quick"))
+	}))
+	defer ser.Close()
+
+	bulkhead := NewBulkhead(0, 2)
+	session := NewSession().SetBulkhead(bulkhead)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := session.Get(ser.URL)
+			if asserts.NoError(err) {
+				asserts.Equal(resp.Body.String(), "quick")
+			}
+		}()
+	}
+	wg.Wait()
+
+	asserts.Equal(atomic.LoadInt32(&maxInFlight), int32(2))
+	asserts.Equal(bulkhead.Stats(), BulkheadStats{})
+}
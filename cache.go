@@ -0,0 +1,562 @@
+package quick
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheStatus reports how the session cache answered a request, see Session.SetCache.
+type CacheStatus string
+
+const (
+	CacheBypass      CacheStatus = "BYPASS"      // request not eligible for caching
+	CacheMiss        CacheStatus = "MISS"        // response fetched from the network
+	CacheHit         CacheStatus = "HIT"         // fresh response served from the cache
+	CacheRevalidated CacheStatus = "REVALIDATED" // stale response confirmed by the server
+)
+
+// cache entry meta headers, stripped when the entry is loaded
+const (
+	cacheRequestTimeHeader  = "X-Quick-Cache-Request-Time"
+	cacheResponseTimeHeader = "X-Quick-Cache-Response-Time"
+	cacheVaryHeaderPrefix   = "X-Quick-Cache-Vary-"
+)
+
+// cacheStatusKey holds the cache status recorder of a request
+var cacheStatusKey = &contextKey{"cache-status"}
+
+// noCacheKey marks the requests bypassing the cache, like streamed ones
+var noCacheKey = &contextKey{"no-cache"}
+
+// cacheRecorder records the cache status of the last request of a redirect chain
+type cacheRecorder struct {
+	status CacheStatus
+}
+
+// fromCache reports whether the response body was served from the cache.
+func (r *cacheRecorder) fromCache() bool {
+	return r.status == CacheHit || r.status == CacheRevalidated
+}
+
+// contextWithCacheRecorder returns a copy of ctx recording the cache status in r.
+func contextWithCacheRecorder(ctx context.Context, r *cacheRecorder) context.Context {
+	return context.WithValue(ctx, cacheStatusKey, r)
+}
+
+// recordCacheStatus records the cache status of req.
+func recordCacheStatus(req *http.Request, status CacheStatus) {
+	if r, ok := req.Context().Value(cacheStatusKey).(*cacheRecorder); ok {
+		r.status = status
+	}
+}
+
+// cacheEntry is a stored response
+type cacheEntry struct {
+	statusCode   int
+	status       string
+	header       http.Header
+	body         []byte
+	vary         http.Header // request values of the Vary headers
+	requestTime  time.Time
+	responseTime time.Time
+}
+
+// cacheKey returns the cache key of u.
+func cacheKey(u *url.URL) string {
+	return http.MethodGet + " " + u.String()
+}
+
+// loadCacheEntry returns the entry of key, nil when missing or unreadable.
+func loadCacheEntry(c Cache, key string) *cacheEntry {
+	data, ok := c.Get(key)
+	if !ok {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil
+	}
+
+	e := &cacheEntry{
+		statusCode: resp.StatusCode,
+		status:     resp.Status,
+		header:     make(http.Header),
+		body:       body,
+		vary:       make(http.Header),
+	}
+	for k, v := range resp.Header {
+		switch {
+		case k == cacheRequestTimeHeader:
+			e.requestTime = parseUnixNano(v[0])
+		case k == cacheResponseTimeHeader:
+			e.responseTime = parseUnixNano(v[0])
+		case strings.HasPrefix(k, cacheVaryHeaderPrefix):
+			e.vary[strings.TrimPrefix(k, cacheVaryHeaderPrefix)] = v
+		default:
+			e.header[k] = v
+		}
+	}
+	return e
+}
+
+// store saves the entry as key, in the HTTP/1.1 response wire format.
+// The header fields named by the no-cache directive are not stored, they
+// are only sent by the server, see RFC 9111 section 5.2.2.4.
+func (e *cacheEntry) store(c Cache, key string) {
+	header := e.header.Clone()
+	if fields := parseCacheControl(header)["no-cache"]; fields != "" {
+		for _, name := range strings.Split(fields, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	header.Set(cacheRequestTimeHeader, strconv.FormatInt(e.requestTime.UnixNano(), 10))
+	header.Set(cacheResponseTimeHeader, strconv.FormatInt(e.responseTime.UnixNano(), 10))
+	for k, v := range e.vary {
+		header[cacheVaryHeaderPrefix+k] = v
+	}
+
+	resp := &http.Response{
+		Status:        e.status,
+		StatusCode:    e.statusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+	}
+	var buf bytes.Buffer
+	if err := resp.Write(&buf); err != nil {
+		return
+	}
+	c.Set(key, buf.Bytes())
+}
+
+// matchVary reports whether req selects the entry, see RFC 9111 section 4.1.
+func (e *cacheEntry) matchVary(req *http.Request) bool {
+	for _, name := range varyHeaders(e.header) {
+		if name == "*" {
+			return false
+		}
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(e.vary.Values(name), ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// freshnessLifetime returns how long the entry is fresh, see RFC 9111 section 4.2.1.
+// The entries are shared by the requests of the session, s-maxage applies.
+func (e *cacheEntry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.header)
+	if v, ok := cc["s-maxage"]; ok {
+		return parseDeltaSeconds(v)
+	}
+	if v, ok := cc["max-age"]; ok {
+		return parseDeltaSeconds(v)
+	}
+
+	date := e.date()
+	if v := e.header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil || expires.Before(date) {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	// heuristic freshness: 10% of the time since the last modification
+	if v := e.header.Get("Last-Modified"); v != "" && heuristicCacheable(e.statusCode) {
+		if modified, err := http.ParseTime(v); err == nil && modified.Before(date) {
+			return date.Sub(modified) / 10
+		}
+	}
+	return 0
+}
+
+// age returns the current age of the entry, see RFC 9111 section 4.2.3.
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	apparentAge := e.responseTime.Sub(e.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	ageValue := parseDeltaSeconds(e.header.Get("Age"))
+	correctedAge := ageValue + e.responseTime.Sub(e.requestTime)
+	if correctedAge < apparentAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(e.responseTime)
+}
+
+// date returns the Date header of the entry, its response time when missing.
+func (e *cacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.header.Get("Date")); err == nil {
+		return date
+	}
+	return e.responseTime
+}
+
+// fresh reports whether the entry can answer a request with the reqCC
+// cache directives without revalidation.
+func (e *cacheEntry) fresh(now time.Time, reqCC map[string]string) bool {
+	respCC := parseCacheControl(e.header)
+	if v, ok := respCC["no-cache"]; ok && v == "" {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+
+	lifetime := e.freshnessLifetime()
+	age := e.age(now)
+	if v, ok := reqCC["max-age"]; ok && age > parseDeltaSeconds(v) {
+		return false
+	}
+	if v, ok := reqCC["min-fresh"]; ok {
+		age += parseDeltaSeconds(v)
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// stale responses the client accepts
+	if _, ok := respCC["must-revalidate"]; ok {
+		return false
+	}
+	v, ok := reqCC["max-stale"]
+	return ok && (v == "" || age-lifetime <= parseDeltaSeconds(v))
+}
+
+// validators reports whether the entry can be revalidated.
+func (e *cacheEntry) validators() bool {
+	return e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+}
+
+// update refreshes the entry with the headers of a 304 response, see RFC 9111 section 3.2.
+func (e *cacheEntry) update(resp *http.Response, requestTime, responseTime time.Time) {
+	for k, v := range resp.Header {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		e.header[k] = v
+	}
+	e.requestTime = requestTime
+	e.responseTime = responseTime
+}
+
+// response returns the entry as the response of req.
+func (e *cacheEntry) response(req *http.Request, now time.Time) *http.Response {
+	header := e.header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	return &http.Response{
+		Status:        e.status,
+		StatusCode:    e.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// cacheRoundTrip sends req through the session cache c.
+//
+// Only GET requests without Range, conditional or Upgrade headers use the
+// cache, streamed requests do not. Fresh entries are served without a network request, stale ones are
+// revalidated with their ETag and Last-Modified validators. A successful
+// unsafe request invalidates the entry of its URL.
+func (session *Session) cacheRoundTrip(c Cache, req *http.Request) (*http.Response, error) {
+	reqCC := parseCacheControl(req.Header)
+	_, noStore := reqCC["no-store"]
+	if noStore || req.Context().Value(noCacheKey) != nil || !cacheableRequest(req) {
+		recordCacheStatus(req, CacheBypass)
+		resp, err := session.roundTrip(req)
+		if err == nil && unsafeMethod(req.Method) && resp.StatusCode < 400 {
+			invalidateCache(c, req.URL, resp)
+		}
+		return resp, err
+	}
+	if len(reqCC) == 0 && req.Header.Get("Pragma") == "no-cache" {
+		reqCC = map[string]string{"no-cache": ""}
+	}
+
+	key := cacheKey(req.URL)
+	entry := loadCacheEntry(c, key)
+	if entry != nil && !entry.matchVary(req) {
+		entry = nil
+	}
+
+	now := time.Now()
+	if entry != nil && entry.fresh(now, reqCC) {
+		recordCacheStatus(req, CacheHit)
+		return entry.response(req, now), nil
+	}
+	if _, ok := reqCC["only-if-cached"]; ok {
+		recordCacheStatus(req, CacheMiss)
+		return &http.Response{
+			Status:        "504 Gateway Timeout",
+			StatusCode:    http.StatusGatewayTimeout,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        make(http.Header),
+			Body:          http.NoBody,
+			ContentLength: 0,
+			Request:       req,
+		}, nil
+	}
+
+	outreq := req
+	if entry != nil && entry.validators() {
+		outreq = req.Clone(req.Context())
+		if etag := entry.header.Get("ETag"); etag != "" {
+			outreq.Header.Set("If-None-Match", etag)
+		}
+		if modified := entry.header.Get("Last-Modified"); modified != "" {
+			outreq.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	requestTime := time.Now()
+	resp, err := session.roundTrip(outreq)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+
+	if outreq != req && resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+		entry.update(resp, requestTime, responseTime)
+		entry.store(c, key)
+		recordCacheStatus(req, CacheRevalidated)
+		return entry.response(req, responseTime), nil
+	}
+
+	recordCacheStatus(req, CacheMiss)
+	if !storableResponse(req, resp) {
+		if entry != nil {
+			c.Delete(key)
+		}
+		return resp, nil
+	}
+
+	stored := &cacheEntry{
+		statusCode:   resp.StatusCode,
+		status:       resp.Status,
+		header:       CopyHeader(resp.Header),
+		vary:         make(http.Header),
+		requestTime:  requestTime,
+		responseTime: responseTime,
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		stored.vary[textproto.CanonicalMIMEHeaderKey(name)] = req.Header.Values(name)
+	}
+	resp.Body = &cacheBody{
+		ReadCloser: resp.Body,
+		store: func(body []byte) {
+			stored.body = body
+			stored.store(c, key)
+		},
+	}
+	return resp, nil
+}
+
+// cacheBody stores the response body in the cache once read entirely.
+type cacheBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	store func(body []byte)
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF && b.store != nil {
+		b.store(b.buf.Bytes())
+		b.store = nil
+	}
+	return n, err
+}
+
+// cacheableRequest reports whether req may be answered from the cache.
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	for _, name := range []string{"Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		if req.Header.Get(name) != "" {
+			return false
+		}
+	}
+	return !upgradeRequest(req)
+}
+
+// upgradeRequest reports whether req asks to switch protocols, e.g. a
+// WebSocket handshake.
+func upgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// storableResponse reports whether resp to req may be stored, see RFC 9111
+// section 3. The cache is shared, private responses are not stored.
+// Responses need an explicit freshness or a validator to be of any use.
+func storableResponse(req *http.Request, resp *http.Response) bool {
+	if !heuristicCacheable(resp.StatusCode) || resp.StatusCode == http.StatusPartialContent {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if _, ok := cc["private"]; ok {
+		return false
+	}
+	// the entries are shared by the requests of the session, whatever their
+	// credentials: responses to authorized requests are stored only when the
+	// server allows it, RFC 9111 section 3.5. The transport may have added
+	// the credentials, resp.Request is the request it sent.
+	if authorized(req) || (resp.Request != nil && authorized(resp.Request)) {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		_, mustRevalidate := cc["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+	if _, ok := cc["max-age"]; ok {
+		return true
+	}
+	if _, ok := cc["s-maxage"]; ok {
+		return true
+	}
+	return resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// authorized reports whether req carries credentials.
+func authorized(req *http.Request) bool {
+	return req.Header.Get("Authorization") != ""
+}
+
+// heuristicCacheable reports whether responses with status code are cacheable by default.
+func heuristicCacheable(code int) bool {
+	switch code {
+	case http.StatusOK,
+		http.StatusNonAuthoritativeInfo,
+		http.StatusNoContent,
+		http.StatusPartialContent,
+		http.StatusMultipleChoices,
+		http.StatusMovedPermanently,
+		http.StatusPermanentRedirect,
+		http.StatusNotFound,
+		http.StatusMethodNotAllowed,
+		http.StatusGone,
+		http.StatusRequestURITooLong,
+		http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// unsafeMethod reports whether method may change the server state.
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// invalidateCache removes the entries of u and of the same host
+// Location and Content-Location of resp, see RFC 9111 section 4.4.
+func invalidateCache(c Cache, u *url.URL, resp *http.Response) {
+	c.Delete(cacheKey(u))
+	for _, name := range []string{"Location", "Content-Location"} {
+		v := resp.Header.Get(name)
+		if v == "" {
+			continue
+		}
+		if loc, err := u.Parse(v); err == nil && loc.Host == u.Host {
+			c.Delete(cacheKey(loc))
+		}
+	}
+}
+
+// varyHeaders returns the header names listed in the Vary header.
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// parseCacheControl returns the Cache-Control directives of header, with lower case names.
+func parseCacheControl(header http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+// parseDeltaSeconds parses a delta-seconds value, 0 when invalid.
+func parseDeltaSeconds(v string) time.Duration {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	// the greatest value, see RFC 9111 section 1.2.2
+	if seconds > 1<<31 {
+		seconds = 1 << 31
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// parseUnixNano parses a time in nanoseconds since the Unix epoch.
+func parseUnixNano(v string) time.Time {
+	nsec, _ := strconv.ParseInt(v, 10, 64)
+	return time.Unix(0, nsec)
+}
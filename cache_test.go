@@ -0,0 +1,256 @@
+package quick
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// RunCacheServer serves cacheable responses and counts the requests reaching it.
+//
+//	/max-age         fresh for a minute
+//	/etag            always revalidated with an ETag
+//	/vary            varies on Accept-Language
+//	/no-store        never stored
+func RunCacheServer(hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(hits, 1)
+		switch r.URL.Path {
+		case "/max-age":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			_, _ = fmt.Fprintf(w, "%s ", r.Header.Get("Accept-Language"))
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		if r.Method == http.MethodGet {
+			_, _ = fmt.Fprintf(w, "response %d", n)
+		}
+	}))
+}
+
+func TestSession_SetCache(t *testing.T) {
+	asserts := assert.New(t)
+
+	var hits int32
+	srv := RunCacheServer(&hits)
+	defer srv.Close()
+
+	session := NewSession().SetCache(NewMemoryCache(0))
+
+	// fresh response
+	resp, err := session.Get(srv.URL + "/max-age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.False(resp.FromCache)
+	asserts.Equal(CacheMiss, resp.CacheStatus)
+	resp, err = session.Get(srv.URL + "/max-age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.True(resp.FromCache)
+	asserts.Equal(CacheHit, resp.CacheStatus)
+	asserts.Equal("response 1", resp.Body.String())
+	asserts.Equal(int32(1), atomic.LoadInt32(&hits))
+
+	// the client asks for a fresh copy
+	resp, _ = session.Get(srv.URL+"/max-age", OptionHeaderSingle("Cache-Control", "no-cache"))
+	asserts.False(resp.FromCache)
+	asserts.Equal("response 2", resp.Body.String())
+
+	// an unsafe request invalidates the entry
+	_, _ = session.Post(srv.URL + "/max-age")
+	resp, _ = session.Get(srv.URL + "/max-age")
+	asserts.Equal(CacheMiss, resp.CacheStatus)
+	asserts.Equal("response 4", resp.Body.String())
+
+	// revalidation
+	atomic.StoreInt32(&hits, 0)
+	resp, _ = session.Get(srv.URL + "/etag")
+	asserts.Equal(CacheMiss, resp.CacheStatus)
+	resp, _ = session.Get(srv.URL + "/etag")
+	asserts.Equal(CacheRevalidated, resp.CacheStatus)
+	asserts.True(resp.FromCache)
+	asserts.Equal(http.StatusOK, resp.StatusCode)
+	asserts.Equal("response 1", resp.Body.String())
+	asserts.Equal(int32(2), atomic.LoadInt32(&hits))
+
+	// vary
+	resp, _ = session.Get(srv.URL+"/vary", OptionHeaderSingle("Accept-Language", "en"))
+	asserts.Equal(CacheMiss, resp.CacheStatus)
+	resp, _ = session.Get(srv.URL+"/vary", OptionHeaderSingle("Accept-Language", "en"))
+	asserts.Equal(CacheHit, resp.CacheStatus)
+	resp, _ = session.Get(srv.URL+"/vary", OptionHeaderSingle("Accept-Language", "fr"))
+	asserts.Equal(CacheMiss, resp.CacheStatus)
+	asserts.Contains(resp.Body.String(), "fr ")
+
+	// no-store
+	_, _ = session.Get(srv.URL + "/no-store")
+	resp, _ = session.Get(srv.URL + "/no-store")
+	asserts.Equal(CacheMiss, resp.CacheStatus)
+
+	// only-if-cached
+	resp, err = session.Get(srv.URL+"/no-store", OptionHeaderSingle("Cache-Control", "only-if-cached"))
+	asserts.NoError(err)
+	asserts.Equal(http.StatusGatewayTimeout, resp.StatusCode)
+
+	// not eligible
+	resp, _ = session.Head(srv.URL + "/max-age")
+	asserts.Equal(CacheBypass, resp.CacheStatus)
+
+	// without cache
+	resp, _ = NewSession().Get(srv.URL + "/max-age")
+	asserts.Equal(CacheStatus(""), resp.CacheStatus)
+}
+
+// roundTripperFunc is an http.RoundTripper function
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSession_SetCache_Authorization(t *testing.T) {
+	asserts := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		_, _ = fmt.Fprintf(w, "hello %s", r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	session := NewSession().SetCache(NewMemoryCache(0))
+	get := func(path, token string) *Response {
+		resp, err := session.Get(srv.URL+path, OptionHeaderSingle("Authorization", token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// not shared between credentials
+	asserts.Equal("hello alice", get("/?cc=max-age=60", "alice").Body.String())
+	resp := get("/?cc=max-age=60", "bob")
+	asserts.Equal("hello bob", resp.Body.String())
+	asserts.False(resp.FromCache)
+
+	// unless the server allows it
+	for _, cc := range []string{"public,max-age=60", "s-maxage=60", "must-revalidate,max-age=60"} {
+		get("/?cc="+cc, "alice")
+		resp = get("/?cc="+cc, "bob")
+		asserts.Equal("hello alice", resp.Body.String(), cc)
+		asserts.True(resp.FromCache, cc)
+	}
+
+	// credentials added by the transport
+	session = NewSession().SetCache(NewMemoryCache(0))
+	base := session.GetTransport()
+	token := "alice"
+	session.SetTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", token)
+		return base.RoundTrip(req)
+	}))
+	resp, _ = session.Get(srv.URL + "/?cc=max-age=60")
+	asserts.Equal("hello alice", resp.Body.String())
+	token = "bob"
+	resp, _ = session.Get(srv.URL + "/?cc=max-age=60")
+	asserts.Equal("hello bob", resp.Body.String())
+}
+
+func TestSession_SetCache_Directives(t *testing.T) {
+	asserts := assert.New(t)
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Header().Set("X-Secret", "s")
+		_, _ = fmt.Fprintf(w, "hello %d", n)
+	}))
+	defer srv.Close()
+
+	session := NewSession().SetCache(NewMemoryCache(0))
+
+	// private responses are not stored
+	session.Get(srv.URL + "/?cc=private,max-age=60")
+	resp, _ := session.Get(srv.URL + "/?cc=private,max-age=60")
+	asserts.False(resp.FromCache)
+	asserts.Equal("hello 2", resp.Body.String())
+
+	// the fields named by no-cache are not stored
+	session.Get(srv.URL + `/?cc=max-age=60,no-cache="X-Secret"`)
+	resp, _ = session.Get(srv.URL + `/?cc=max-age=60,no-cache="X-Secret"`)
+	asserts.True(resp.FromCache)
+	asserts.Equal("hello 3", resp.Body.String())
+	asserts.Empty(resp.Header.Get("X-Secret"))
+
+	// streamed requests bypass the cache
+	session.Get(srv.URL + "/?cc=max-age=60")
+	resp, err := session.SuckStream(NewRequest().SetUrl(srv.URL + "/?cc=max-age=60"))
+	if asserts.NoError(err) {
+		body, _ := ioutil.ReadAll(resp)
+		_ = resp.Close()
+		asserts.Equal("hello 5", string(body))
+		asserts.Equal(CacheBypass, resp.CacheStatus)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	asserts := assert.New(t)
+
+	var hits int32
+	srv := RunCacheServer(&hits)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = NewSession().SetCache(c).Get(srv.URL + "/max-age")
+
+	// entries outlive the session
+	c, _ = NewDiskCache(dir)
+	resp, err := NewSession().SetCache(c).Get(srv.URL + "/max-age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.True(resp.FromCache)
+	asserts.Equal("response 1", resp.Body.String())
+
+	c.Delete(cacheKey(resp.HttpRequest.URL))
+	_, ok := c.Get(cacheKey(resp.HttpRequest.URL))
+	asserts.False(ok)
+}
+
+func TestMemoryCache_Evict(t *testing.T) {
+	asserts := assert.New(t)
+
+	c := NewMemoryCache(2)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Get("a")
+	c.Set("c", []byte("3"))
+
+	asserts.Equal(2, c.Len())
+	_, ok := c.Get("b")
+	asserts.False(ok)
+	v, ok := c.Get("a")
+	asserts.True(ok)
+	asserts.Equal([]byte("1"), v)
+}
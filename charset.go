@@ -0,0 +1,67 @@
+package quick
+
+import (
+	"errors"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrUnknownCharset is returned when a forced response charset is not known.
+var ErrUnknownCharset = errors.New("unknown charset")
+
+// isTextContent reports whether a body of contentType is text, and so
+// may be transcoded. The body is sniffed when contentType is empty.
+func isTextContent(contentType string, body []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/xml",
+		mediaType == "application/javascript",
+		mediaType == "application/ecmascript",
+		mediaType == "application/x-javascript":
+		return true
+	}
+	return false
+}
+
+// lookupCharset returns the encoding of a charset name or label.
+func lookupCharset(name string) (encoding.Encoding, error) {
+	coding, _ := charset.Lookup(name)
+	if coding == nil {
+		return nil, WrapErrf(ErrUnknownCharset, "charset %q", name)
+	}
+	return coding, nil
+}
+
+// detectCharset returns the encoding of a text body, from its byte order mark,
+// the Content-Type header or the HTML meta tags, UTF-8 by default.
+func detectCharset(body []byte, contentType string) encoding.Encoding {
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	coding, _, _ := charset.DetermineEncoding(body, contentType)
+	if coding == nil {
+		return unicode.UTF8
+	}
+	return coding
+}
+
+// decodeCharset converts body from coding to UTF-8.
+func decodeCharset(body []byte, coding encoding.Encoding) ([]byte, error) {
+	if coding == encoding.Nop || coding == unicode.UTF8 {
+		return body, nil
+	}
+	return coding.NewDecoder().Bytes(body)
+}
@@ -0,0 +1,163 @@
+package quick
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Content-Encoding names
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+)
+
+// acceptEncoding is the Accept-Encoding header sent when decompression is enabled
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// ErrUnsupportedEncoding is returned for a content encoding quick can not handle.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// contentEncodings returns the codings of a Content-Encoding header, in the order applied.
+func contentEncodings(header http.Header) []string {
+	codings := make([]string, 0)
+	for _, v := range header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	return codings
+}
+
+// decodable reports whether quick can decode every coding of codings.
+// Bodies of other encodings are left as received, Content-Encoding included.
+func decodable(codings []string) bool {
+	for _, coding := range codings {
+		switch coding {
+		case EncodingGzip, "x-gzip", EncodingDeflate, EncodingBrotli, EncodingZstd:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// setAcceptEncoding asks for a compressed response, like http.Transport does for gzip.
+func setAcceptEncoding(req *http.Request) {
+	if req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" || req.Method == http.MethodHead {
+		return
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+}
+
+// decompressReader returns a reader decoding r, encoded with codings in order.
+func decompressReader(r io.Reader, codings []string) (io.ReadCloser, error) {
+	rc := ioutil.NopCloser(r)
+	for i := len(codings) - 1; i >= 0; i-- {
+		next, err := newDecoder(rc, codings[i])
+		if err != nil {
+			_ = rc.Close()
+			return nil, err
+		}
+		rc = next
+	}
+	return rc, nil
+}
+
+// decoder closes the decoder and its underlying reader.
+type decoder struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decoder) Close() error {
+	var err error
+	for _, c := range d.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func newDecoder(rc io.ReadCloser, coding string) (io.ReadCloser, error) {
+	switch coding {
+	case EncodingGzip, "x-gzip":
+		zr, err := gzip.NewReader(rc)
+		if err != nil {
+			return nil, err
+		}
+		return &decoder{Reader: zr, closers: []io.Closer{zr, rc}}, nil
+	case EncodingDeflate:
+		// deflate should be zlib wrapped, but some servers send raw deflate
+		br := bufio.NewReader(rc)
+		header, _ := br.Peek(2)
+		if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			return &decoder{Reader: zr, closers: []io.Closer{zr, rc}}, nil
+		}
+		fr := flate.NewReader(br)
+		return &decoder{Reader: fr, closers: []io.Closer{fr, rc}}, nil
+	case EncodingBrotli:
+		return &decoder{Reader: brotli.NewReader(rc), closers: []io.Closer{rc}}, nil
+	case EncodingZstd:
+		zr, err := zstd.NewReader(rc, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &decoder{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), rc}}, nil
+	}
+	return nil, WrapErrf(ErrUnsupportedEncoding, "decode %q body", coding)
+}
+
+// compressBody encodes body with coding.
+func compressBody(body io.Reader, coding string) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	switch strings.ToLower(coding) {
+	case EncodingGzip:
+		w = gzip.NewWriter(buf)
+	case EncodingDeflate:
+		w = zlib.NewWriter(buf)
+	case EncodingBrotli:
+		w = brotli.NewWriter(buf)
+	case EncodingZstd:
+		w, err = zstd.NewWriter(buf, zstd.WithEncoderConcurrency(1))
+	default:
+		err = WrapErrf(ErrUnsupportedEncoding, "compress body with %q", coding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		if _, err := io.Copy(w, body); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
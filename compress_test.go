@@ -0,0 +1,146 @@
+package quick
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// RunCompressServer answers with its body encoded as the "enc" query asks
+// and echoes the decoded request body.
+func RunCompressServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ce := r.Header.Get("Content-Encoding"); ce != "" {
+			reader, err := decompressReader(r.Body, []string{ce})
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, _ := ioutil.ReadAll(reader)
+			_, _ = w.Write(append([]byte(ce+":"), body...))
+			return
+		}
+
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		enc := r.URL.Query().Get("enc")
+		if enc == "" {
+			_, _ = w.Write([]byte("quick"))
+			return
+		}
+		body := strings.NewReader("quick")
+		for _, coding := range strings.Split(enc, ",") {
+			buf, _ := compressBody(body, coding)
+			body = strings.NewReader(buf.String())
+		}
+		w.Header().Set("Content-Encoding", strings.Replace(enc, ",", ", ", -1))
+		_, _ = body.WriteTo(w)
+	}))
+}
+
+func TestSession_Decompression(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunCompressServer()
+	defer ser.Close()
+
+	session := NewSession()
+	for _, enc := range []string{EncodingGzip, EncodingDeflate, EncodingBrotli, EncodingZstd, "gzip,br"} {
+		resp, err := session.Get(ser.URL + "?enc=" + enc)
+		if err != nil {
+			t.Fatal(enc, err)
+		}
+		asserts.Equal(resp.Body.String(), "quick", enc)
+		asserts.True(resp.Uncompressed, enc)
+		asserts.Empty(resp.GetHeaderSingle("Content-Encoding"), enc)
+		asserts.NotEqual(resp.RawBody(), []byte("quick"), enc)
+		asserts.Equal(resp.GetHeaderSingle("X-Accept-Encoding"), acceptEncoding)
+	}
+
+	// the request Accept-Encoding is kept
+	resp, err := session.Get(ser.URL+"?enc=br", OptionHeaderSingle("Accept-Encoding", "br"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "quick")
+	asserts.Equal(resp.GetHeaderSingle("X-Accept-Encoding"), "br")
+
+	// disabled decompression leaves the body as received
+	session.DisableDecompression()
+	resp, err = session.Get(ser.URL+"?enc=zstd", OptionHeaderSingle("Accept-Encoding", "zstd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.False(resp.Uncompressed)
+	asserts.Equal(resp.GetHeaderSingle("Content-Encoding"), "zstd")
+	asserts.Equal(resp.RawBody(), mustCompress(t, EncodingZstd))
+
+	// an unknown encoding is left as received
+	unknown := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip, x-custom")
+		_, _ = w.Write([]byte("encoded"))
+	}))
+	defer unknown.Close()
+	resp, err = NewSession().Get(unknown.URL)
+	if asserts.NoError(err) {
+		asserts.False(resp.Uncompressed)
+		asserts.Equal("gzip, x-custom", resp.GetHeaderSingle("Content-Encoding"))
+		asserts.Equal("encoded", resp.Body.String())
+	}
+	resp, err = NewSession().SuckStream(NewRequest().SetUrl(unknown.URL))
+	if asserts.NoError(err) {
+		body, _ := ioutil.ReadAll(resp)
+		_ = resp.Close()
+		asserts.Equal("encoded", string(body))
+	}
+
+	// raw deflate without zlib header
+	raw, _ := decompressBytes(mustCompress(t, "deflate"), []string{EncodingDeflate})
+	asserts.Equal(string(raw), "quick")
+}
+
+func mustCompress(t *testing.T, coding string) []byte {
+	buf, err := compressBody(strings.NewReader("quick"), coding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSession_CompressBody(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunCompressServer()
+	defer ser.Close()
+
+	for _, coding := range []string{EncodingGzip, EncodingDeflate, EncodingBrotli, EncodingZstd} {
+		resp, err := Post(ser.URL, OptionBody(bytes.Repeat([]byte("a"), 4096)), OptionCompressBody(coding))
+		if err != nil {
+			t.Fatal(coding, err)
+		}
+		asserts.Equal(resp.Body.String(), coding+":"+strings.Repeat("a", 4096))
+	}
+
+	_, err := Post(ser.URL, OptionBody("quick"), OptionCompressBody("lzma"))
+	asserts.Error(err)
+
+	// the request body is kept, the request can be sent again
+	req := NewRequest().SetUrl(ser.URL).SetMethod(http.MethodPost).SetCompressBody(EncodingGzip)
+	req.Body = strings.NewReader("quick")
+	session := NewSession()
+	for i := 0; i < 2; i++ {
+		resp, err := session.Suck(req)
+		if asserts.NoError(err) {
+			asserts.Equal("gzip:quick", resp.Body.String())
+		}
+	}
+
+	// a request without body is not compressed
+	resp, err := session.Get(ser.URL, OptionCompressBody(EncodingGzip))
+	if asserts.NoError(err) {
+		asserts.Equal("quick", resp.Body.String())
+	}
+}
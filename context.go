@@ -0,0 +1,83 @@
+package quick
+
+import (
+	"context"
+	"net/url"
+)
+
+// contextKey is the type of quick request context keys.
+type contextKey struct {
+	name string
+}
+
+var (
+	// proxyKey holds the proxy url of a request
+	proxyKey = &contextKey{"proxy"}
+	// redirectNumKey holds the redirect number of a request
+	redirectNumKey = &contextKey{"redirect-num"}
+	// attrsKey holds the attributes of a request
+	attrsKey = &contextKey{"attrs"}
+	// resolvedProxyKey holds the proxy the session resolved for a request.
+	resolvedProxyKey = &contextKey{"resolved-proxy"}
+)
+
+const (
+	// ContextProxyKey is the former request context key of the proxy url.
+	//
+	// Deprecated: use ContextWithProxy and ProxyFromContext.
+	ContextProxyKey = "proxy"
+	// ContextRedirectNumKey is the former request context key of the redirect number.
+	//
+	// Deprecated: use ContextWithRedirectNum and RedirectNumFromContext.
+	ContextRedirectNumKey = "redirectNum"
+)
+
+// ContextWithProxy returns a copy of ctx requesting the proxy u.
+func ContextWithProxy(ctx context.Context, u *url.URL) context.Context {
+	return context.WithValue(ctx, proxyKey, u)
+}
+
+// ProxyFromContext returns the request proxy of ctx,
+// a proxy set with the deprecated ContextProxyKey is still honoured.
+func ProxyFromContext(ctx context.Context) (*url.URL, bool) {
+	u, ok := ctx.Value(proxyKey).(*url.URL)
+	if !ok {
+		u, ok = ctx.Value(ContextProxyKey).(*url.URL)
+	}
+	return u, ok && u != nil
+}
+
+// ContextWithRedirectNum returns a copy of ctx allowing n redirects.
+func ContextWithRedirectNum(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, redirectNumKey, n)
+}
+
+// RedirectNumFromContext returns the number of redirects ctx allows,
+// a number set with the deprecated ContextRedirectNumKey is still honoured.
+func RedirectNumFromContext(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(redirectNumKey).(int)
+	if !ok {
+		n, ok = ctx.Value(ContextRedirectNumKey).(int)
+	}
+	return n, ok
+}
+
+// contextWithAttrs returns a copy of ctx with the request attributes.
+func contextWithAttrs(ctx context.Context, attrs map[string]interface{}) context.Context {
+	if len(attrs) == 0 {
+		return ctx
+	}
+	copyAttrs := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		copyAttrs[k] = v
+	}
+	return context.WithValue(ctx, attrsKey, copyAttrs)
+}
+
+// AttrFromContext returns the request attribute key of ctx,
+// see Request.SetAttr.
+func AttrFromContext(ctx context.Context, key string) (interface{}, bool) {
+	attrs, _ := ctx.Value(attrsKey).(map[string]interface{})
+	v, ok := attrs[key]
+	return v, ok
+}
@@ -0,0 +1,181 @@
+package quick
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultDedupHeaders are the request headers telling identical requests apart by default
+var defaultDedupHeaders = []string{"Authorization", "Cookie", "Accept", "Accept-Encoding", "Accept-Language", "Range"}
+
+// noDedupKey marks the requests never sharing their response, like hedged attempts
+var noDedupKey = &contextKey{"no-dedup"}
+
+// dedupCall is a request in flight and the requests waiting for its response
+type dedupCall struct {
+	done chan struct{}
+	resp *Response
+	err  error
+	dups int
+	// the request failed because the context of its caller ended
+	cancelled bool
+}
+
+// dedup coalesces identical GET and HEAD requests in flight into one network call
+type dedup struct {
+	headers []string
+	mu      sync.Mutex
+	calls   map[string]*dedupCall
+}
+
+// newDedup returns a dedup telling requests apart by the default headers and headers.
+func newDedup(headers []string) *dedup {
+	all := append([]string(nil), defaultDedupHeaders...)
+	for _, name := range headers {
+		known := false
+		for _, h := range all {
+			known = known || strings.EqualFold(h, name)
+		}
+		if !known {
+			all = append(all, name)
+		}
+	}
+	return &dedup{
+		headers: all,
+		calls:   make(map[string]*dedupCall),
+	}
+}
+
+// key returns the key of req, requests with the same key are identical.
+func (d *dedup) key(req *http.Request, opts responseOptions) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.String())
+	if req.Host != "" {
+		b.WriteString("\nHost: ")
+		b.WriteString(req.Host)
+	}
+	for _, name := range d.headers {
+		b.WriteString("\n")
+		b.WriteString(http.CanonicalHeaderKey(name))
+		b.WriteString(": ")
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	// requests built differently do not share their response
+	b.WriteString("\n")
+	b.WriteString(strconv.FormatBool(opts.decompress))
+	b.WriteString(strconv.FormatBool(opts.rawBody))
+	b.WriteString(opts.charset)
+
+	// nor requests sent differently: through another proxy,
+	// following redirects differently, or with other attributes
+	ctx := req.Context()
+	if proxyURL, ok := ProxyFromContext(ctx); ok {
+		b.WriteString("\nProxy: ")
+		b.WriteString(proxyURL.String())
+	}
+	if n, ok := RedirectNumFromContext(ctx); ok {
+		b.WriteString("\nRedirects: ")
+		b.WriteString(strconv.Itoa(n))
+	}
+	if policy, ok := ctx.Value(redirectPolicyKey).(*RedirectPolicy); ok {
+		_, _ = fmt.Fprintf(&b, "\nRedirect-Policy: %p", policy)
+	}
+	if attrs, ok := ctx.Value(attrsKey).(map[string]interface{}); ok && len(attrs) > 0 {
+		// fmt prints maps sorted by key
+		_, _ = fmt.Fprintf(&b, "\nAttrs: %v", attrs)
+	}
+	return b.String()
+}
+
+// do calls fn for req, unless an identical request is in flight: its
+// response is then shared. Every caller gets its own copy of the Response.
+// When the request in flight fails because the context of its caller ended,
+// a waiting request is sent again.
+func (d *dedup) do(req *http.Request, opts responseOptions, fn func() (*Response, error)) (*Response, error) {
+	key := d.key(req, opts)
+
+	d.mu.Lock()
+	for {
+		c, ok := d.calls[key]
+		if !ok {
+			break
+		}
+		c.dups++
+		d.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-req.Context().Done():
+			err := req.Context().Err()
+			if err == context.DeadlineExceeded {
+				return nil, WrapErr(ErrTimeout, err.Error())
+			}
+			return nil, WrapErr(err, "Request Error")
+		}
+		if c.cancelled {
+			d.mu.Lock()
+			continue
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+		resp := c.resp.clone()
+		resp.Shared = true
+		// the request of the waiter, for its attributes
+		if resp.HttpRequest != nil {
+			resp.HttpRequest = resp.HttpRequest.WithContext(req.Context())
+		}
+		return resp, nil
+	}
+	c := &dedupCall{done: make(chan struct{})}
+	d.calls[key] = c
+	d.mu.Unlock()
+
+	c.resp, c.err = fn()
+	c.cancelled = c.err != nil && req.Context().Err() != nil
+
+	d.mu.Lock()
+	delete(d.calls, key)
+	shared := c.dups > 0
+	d.mu.Unlock()
+	close(c.done)
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	if !shared {
+		return c.resp, nil
+	}
+	resp := c.resp.clone()
+	resp.Shared = true
+	return resp, nil
+}
+
+// dedupable reports whether req may share the response of an identical request.
+func dedupable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Context().Value(noDedupKey) != nil || upgradeRequest(req) {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// clone returns a copy of the response, sharing nothing mutable.
+func (r *Response) clone() *Response {
+	resp := *r
+	resp.Header = CopyHeader(r.Header)
+	resp.Body = bytes.NewBuffer(append([]byte(nil), r.Body.Bytes()...))
+	resp.TransferEncoding = append([]string(nil), r.TransferEncoding...)
+	resp.redirects = append([]RedirectHop(nil), r.redirects...)
+	resp.rawBody = append([]byte(nil), r.rawBody...)
+	resp.content = append([]byte(nil), r.content...)
+	return &resp
+}
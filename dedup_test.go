@@ -0,0 +1,223 @@
+package quick
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitDedup waits until n requests wait for the request in flight.
+func waitDedup(t *testing.T, d *dedup, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		dups := 0
+		for _, c := range d.calls {
+			dups += c.dups
+		}
+		d.mu.Unlock()
+		if dups == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d requests never waited", n)
+}
+
+func TestSession_EnableDedup(t *testing.T) {
+	asserts := assert.New(t)
+
+	var hits int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		<-release
+		_, _ = fmt.Fprintf(w, "response %d %s", n, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	session := NewSession().EnableDedup()
+
+	const n = 5
+	resps := make([]*Response, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := session.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resps[i] = resp
+		}(i)
+	}
+	waitDedup(t, session.dedup, n-1)
+	close(release)
+	wg.Wait()
+
+	asserts.Equal(int32(1), atomic.LoadInt32(&hits))
+	for _, resp := range resps {
+		if asserts.NotNil(resp) {
+			asserts.True(resp.Shared)
+			asserts.Equal("response 1 ", resp.Body.String())
+		}
+	}
+	// every caller owns its copy
+	resps[0].Body.Reset()
+	asserts.Equal("response 1 ", resps[1].Body.String())
+
+	// different selected headers are different requests
+	atomic.StoreInt32(&hits, 0)
+	resp, err := session.Get(srv.URL, OptionHeaderSingle("Authorization", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.False(resp.Shared)
+	asserts.Equal("response 1 a", resp.Body.String())
+
+	// unsafe methods are never coalesced
+	var posts sync.WaitGroup
+	for i := 0; i < n; i++ {
+		posts.Add(1)
+		go func() {
+			defer posts.Done()
+			_, _ = session.Post(srv.URL)
+		}()
+	}
+	posts.Wait()
+	asserts.Equal(int32(n+1), atomic.LoadInt32(&hits))
+}
+
+func TestSession_EnableDedup_Options(t *testing.T) {
+	asserts := assert.New(t)
+
+	var hits int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+	}))
+	defer srv.Close()
+
+	session := NewSession().EnableDedup()
+	// sends two concurrent requests, the second one waiting
+	// for the first one when they are identical
+	pair := func(op1, op2 OptionFunc) (*Response, *Response) {
+		atomic.StoreInt32(&hits, 0)
+		release = make(chan struct{})
+		resps := make([]*Response, 2)
+		var wg sync.WaitGroup
+		for i, op := range []OptionFunc{op1, op2} {
+			wg.Add(1)
+			go func(i int, op OptionFunc) {
+				defer wg.Done()
+				resps[i], _ = session.Get(srv.URL, op)
+			}(i, op)
+			if i == 0 {
+				for atomic.LoadInt32(&hits) == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return resps[0], resps[1]
+	}
+
+	policy := &RedirectPolicy{}
+	tests := []struct {
+		name     string
+		op1, op2 OptionFunc
+	}{
+		{"redirect num", OptionRedirectNum(1), OptionRedirectNum(2)},
+		{"redirect policy", OptionRedirectPolicy(policy), OptionRedirectPolicy(&RedirectPolicy{})},
+		{"proxy", OptionProxy(""), OptionProxy(srv.URL)},
+		{"attrs", OptionAttr("id", 1), OptionAttr("id", 2)},
+	}
+	for _, test := range tests {
+		pair(test.op1, test.op2)
+		asserts.Equal(int32(2), atomic.LoadInt32(&hits), test.name)
+	}
+
+	// identical requests share the response, each with its own request
+	r1, r2 := pair(OptionAttr("id", 1), OptionAttr("id", 1))
+	asserts.Equal(int32(1), atomic.LoadInt32(&hits))
+	if asserts.NotNil(r1) && asserts.NotNil(r2) {
+		asserts.True(r2.Shared)
+		asserts.Equal(1, r2.GetAttr("id"))
+		asserts.NotSame(r1.HttpRequest, r2.HttpRequest)
+	}
+}
+
+func TestSession_EnableDedup_LeaderTimeout(t *testing.T) {
+	asserts := assert.New(t)
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = fmt.Fprint(w, "done")
+	}))
+	defer srv.Close()
+
+	session := NewSession().EnableDedup()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := session.Get(srv.URL, OptionTimeout(50*time.Millisecond))
+		asserts.ErrorIs(err, ErrTimeout)
+	}()
+	waitDedupCall(t, session.dedup)
+
+	// the waiter is sent again rather than failing with the timeout of the leader
+	resp, err := session.Get(srv.URL)
+	if asserts.NoError(err) {
+		asserts.Equal("done", resp.Body.String())
+		asserts.False(resp.Shared)
+	}
+	wg.Wait()
+	asserts.Equal(int32(2), atomic.LoadInt32(&hits))
+}
+
+// waitDedupCall waits until a request is in flight.
+func waitDedupCall(t *testing.T, d *dedup) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		n := len(d.calls)
+		d.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no request in flight")
+}
+
+func TestSession_EnableDedup_Headers(t *testing.T) {
+	asserts := assert.New(t)
+
+	d := newDedup([]string{"X-Tenant", "cookie"})
+	asserts.Equal(append(append([]string(nil), defaultDedupHeaders...), "X-Tenant"), d.headers)
+
+	// the default headers are kept with the given ones
+	req := func(tenant, auth string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.Header.Set("X-Tenant", tenant)
+		r.Header.Set("Authorization", auth)
+		return r
+	}
+	opts := responseOptions{}
+	asserts.Equal(d.key(req("a", "x"), opts), d.key(req("a", "x"), opts))
+	asserts.NotEqual(d.key(req("a", "x"), opts), d.key(req("b", "x"), opts))
+	asserts.NotEqual(d.key(req("a", "x"), opts), d.key(req("a", "y"), opts))
+}
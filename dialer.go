@@ -0,0 +1,378 @@
+package quick
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DialFunc dials a connection to the address on the named network.
+// See net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// UnixSocketDialer returns a DialFunc that connects to the unix domain
+// socket at path, whatever address the transport asks for.
+//
+//	session.SetHostDialer("docker", quick.UnixSocketDialer("/var/run/docker.sock"))
+//	resp, err := session.Get("http://docker/containers/json")
+func UnixSocketDialer(path string) DialFunc {
+	d := &net.Dialer{}
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+// IPFamily controls which IP address families the session dials.
+type IPFamily int
+
+const (
+	// IPFamilyAny dials addresses in the order of the resolver,
+	// racing the other family after the fallback delay (Happy Eyeballs).
+	IPFamilyAny IPFamily = iota
+	// IPv4Only only dials IPv4 addresses.
+	IPv4Only
+	// IPv6Only only dials IPv6 addresses.
+	IPv6Only
+	// PreferIPv4 dials IPv4 addresses first,
+	// racing IPv6 addresses after the fallback delay.
+	PreferIPv4
+	// PreferIPv6 dials IPv6 addresses first,
+	// racing IPv4 addresses after the fallback delay.
+	PreferIPv6
+)
+
+// DefaultFallbackDelay is the delay before racing the fallback address family.
+const DefaultFallbackDelay = 300 * time.Millisecond
+
+// dialer is the session connection dialer.
+//
+// Host overrides rewrite the dialed address first. Then per-host dialers
+// take precedence over the unix socket, which takes precedence over the
+// base dial func. Only the base dial func honors the resolver and the
+// IP family preference.
+type dialer struct {
+	mu            sync.RWMutex
+	base          DialFunc
+	dial          DialFunc
+	hosts         map[string]DialFunc
+	overrides     map[string]string
+	unixSocket    string
+	resolver      Resolver
+	family        IPFamily
+	fallbackDelay time.Duration
+}
+
+// newDialer create a session dialer based on net.Dialer
+func newDialer(d *net.Dialer) *dialer {
+	return &dialer{
+		base:          d.DialContext,
+		dial:          d.DialContext,
+		hosts:         make(map[string]DialFunc),
+		overrides:     make(map[string]string),
+		fallbackDelay: d.FallbackDelay,
+	}
+}
+
+// DialContext dials addr with the dial func matching it.
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial, target, base := d.route(addr)
+	if !base {
+		return dial(ctx, network, target)
+	}
+	return d.dialBase(ctx, dial, network, target)
+}
+
+// route returns the dial func and the address to dial for addr ("host:port"),
+// and whether the dial func is the base one.
+func (d *dialer) route(addr string) (DialFunc, string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	target := d.override(addr)
+	if dial, ok := d.hosts[addr]; ok {
+		return dial, target, false
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if dial, ok := d.hosts[host]; ok {
+			return dial, target, false
+		}
+	}
+	if d.unixSocket != "" {
+		return UnixSocketDialer(d.unixSocket), target, false
+	}
+	return d.dial, target, true
+}
+
+// override returns the address addr is overridden to, or addr itself.
+// An override without a port keeps the port of addr.
+func (d *dialer) override(addr string) string {
+	if to, ok := d.overrides[addr]; ok {
+		return to
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	to, ok := d.overrides[host]
+	if !ok {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(to); err == nil {
+		return to
+	}
+	return net.JoinHostPort(to, port)
+}
+
+// overrideAddr returns the address addr is overridden to, or addr itself.
+func (d *dialer) overrideAddr(addr string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.override(addr)
+}
+
+// LookupIPAddr implements Resolver with the dialer resolver, so that
+// PAC scripts resolve hosts like the session does.
+func (d *dialer) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return d.lookupIPAddr(ctx, host)
+}
+
+// lookupIPAddr resolves host with the dialer resolver, or the system one.
+func (d *dialer) lookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	d.mu.RLock()
+	resolver := d.resolver
+	d.mu.RUnlock()
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return lookupIPAddr(ctx, resolver, host)
+}
+
+// dialBase dials addr with the base dial func, resolving its host with
+// the resolver and ordering the addresses by the IP family preference.
+func (d *dialer) dialBase(ctx context.Context, dial DialFunc, network, addr string) (net.Conn, error) {
+	d.mu.RLock()
+	resolver, family, delay := d.resolver, d.family, d.fallbackDelay
+	d.mu.RUnlock()
+
+	if network == "tcp" {
+		switch family {
+		case IPv4Only:
+			network = "tcp4"
+		case IPv6Only:
+			network = "tcp6"
+		}
+	}
+
+	// the base dial func resolves and races the families itself
+	if resolver == nil && family != PreferIPv4 && family != PreferIPv6 {
+		return dial(ctx, network, addr)
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return dial(ctx, network, addr)
+	}
+
+	ips, err := lookupIPAddr(ctx, resolver, host)
+	if err != nil {
+		return nil, err
+	}
+	primaries, fallbacks := partitionAddrs(ips, family)
+	if len(primaries) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	if delay < 0 {
+		// a negative delay disables Happy Eyeballs, like net.Dialer
+		return dialSerial(ctx, dial, network, port, append(primaries, fallbacks...))
+	}
+	if delay == 0 {
+		delay = DefaultFallbackDelay
+	}
+	return dialParallel(ctx, dial, network, port, primaries, fallbacks, delay)
+}
+
+// partitionAddrs splits addrs into the primary addresses, dialed first,
+// and the fallback addresses according to the family preference.
+func partitionAddrs(addrs []net.IPAddr, family IPFamily) (primaries, fallbacks []net.IPAddr) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	var v4, v6 []net.IPAddr
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+
+	switch family {
+	case IPv4Only:
+		return v4, nil
+	case IPv6Only:
+		return v6, nil
+	case PreferIPv4:
+		primaries, fallbacks = v4, v6
+	case PreferIPv6:
+		primaries, fallbacks = v6, v4
+	default:
+		// keep the resolver order, the family of the first address is primary
+		if addrs[0].IP.To4() != nil {
+			primaries, fallbacks = v4, v6
+		} else {
+			primaries, fallbacks = v6, v4
+		}
+	}
+	if len(primaries) == 0 {
+		return fallbacks, nil
+	}
+	return primaries, fallbacks
+}
+
+// dialParallel races two copies of dialSerial, giving the first a
+// head start of delay. It returns the first established connection
+// and closes the others. See RFC 8305 (Happy Eyeballs).
+func dialParallel(ctx context.Context, dial DialFunc, network, port string, primaries, fallbacks []net.IPAddr, delay time.Duration) (net.Conn, error) {
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, dial, network, port, primaries)
+	}
+
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+
+	returned := make(chan struct{})
+	defer close(returned)
+
+	results := make(chan dialResult)
+	racer := func(ctx context.Context, addrs []net.IPAddr, primary bool) {
+		conn, err := dialSerial(ctx, dial, network, port, addrs)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: primary}:
+		case <-returned:
+			if conn != nil {
+				_ = conn.Close()
+			}
+		}
+	}
+
+	primaryCtx, primaryCancel := context.WithCancel(ctx)
+	defer primaryCancel()
+	go racer(primaryCtx, primaries, true)
+
+	fallbackCtx, fallbackCancel := context.WithCancel(ctx)
+	defer fallbackCancel()
+	fallbackTimer := time.NewTimer(delay)
+	defer fallbackTimer.Stop()
+
+	var primaryErr error
+	pending := 2
+	for {
+		select {
+		case <-fallbackTimer.C:
+			go racer(fallbackCtx, fallbacks, false)
+
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+			}
+			pending--
+			if pending == 0 {
+				if primaryErr != nil {
+					return nil, primaryErr
+				}
+				return nil, res.err
+			}
+			// start the fallback immediately when the primaries failed
+			if res.primary && fallbackTimer.Stop() {
+				fallbackTimer.Reset(0)
+			}
+		}
+	}
+}
+
+// dialSerial dials the addresses in order until one succeeds.
+func dialSerial(ctx context.Context, dial DialFunc, network, port string, addrs []net.IPAddr) (net.Conn, error) {
+	var firstErr error
+	for _, ip := range addrs {
+		conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// setDial replaces the base dial func. nil restores the default one.
+func (d *dialer) setDial(dial DialFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dial == nil {
+		dial = d.base
+	}
+	d.dial = dial
+}
+
+// setHost sets the dial func of a host or "host:port". nil removes it.
+func (d *dialer) setHost(host string, dial DialFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dial == nil {
+		delete(d.hosts, host)
+		return
+	}
+	d.hosts[host] = dial
+}
+
+// setOverride dials addr ("host:port" or "host") to another address. An empty to removes it.
+func (d *dialer) setOverride(addr, to string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if to == "" {
+		delete(d.overrides, addr)
+		return
+	}
+	d.overrides[addr] = to
+}
+
+// setResolver resolves host names with r before dialing. nil uses the dial func resolution.
+func (d *dialer) setResolver(r Resolver) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resolver = r
+}
+
+// setFamily sets the IP family preference and the delay before racing the fallback family.
+func (d *dialer) setFamily(family IPFamily, fallbackDelay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.family = family
+	d.fallbackDelay = fallbackDelay
+}
+
+// setUnixSocket routes every connection without a per-host dialer to path.
+// An empty path disables it.
+func (d *dialer) setUnixSocket(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unixSocket = path
+}
@@ -0,0 +1,171 @@
+package quick
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func RunUnixServer(t *testing.T) (*httptest.Server, string) {
+	path := filepath.Join(t.TempDir(), "quick.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix socket not supported: %s", err)
+	}
+	ser := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("unix " + r.Host + r.URL.Path))
+	}))
+	ser.Listener = l
+	ser.Start()
+	return ser, path
+}
+
+func TestSession_UnixSocket(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser, path := RunUnixServer(t)
+	defer ser.Close()
+
+	opts := DefaultSessionOptions()
+	opts.UnixSocket = path
+
+	resp, err := NewSession(opts).Get("http://unix/containers/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "unix unix/containers/json")
+}
+
+func TestSession_SetHostDialer(t *testing.T) {
+	asserts := assert.New(t)
+
+	unixSer, path := RunUnixServer(t)
+	defer unixSer.Close()
+	ser := RunServer()
+	defer ser.Close()
+
+	session := NewSession().SetHostDialer("docker", UnixSocketDialer(path))
+
+	resp, err := session.Get("http://docker/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "unix docker/version")
+
+	// other hosts keep the default dialer
+	resp, err = session.Get(ser.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "quick")
+}
+
+func TestSession_SetDialer(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+
+	var dials int32
+	d := &net.Dialer{}
+	session := NewSession().SetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return d.DialContext(ctx, network, ser.Listener.Addr().String())
+	})
+
+	resp, err := session.Get("http://quick.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "quick")
+	asserts.Equal(atomic.LoadInt32(&dials), int32(1))
+}
+
+func TestPartitionAddrs(t *testing.T) {
+	asserts := assert.New(t)
+
+	v4 := net.IPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	v6 := net.IPAddr{IP: net.IPv6loopback}
+	addrs := []net.IPAddr{v6, v4}
+
+	primaries, fallbacks := partitionAddrs(addrs, IPFamilyAny)
+	asserts.Equal(primaries, []net.IPAddr{v6})
+	asserts.Equal(fallbacks, []net.IPAddr{v4})
+
+	primaries, fallbacks = partitionAddrs(addrs, PreferIPv4)
+	asserts.Equal(primaries, []net.IPAddr{v4})
+	asserts.Equal(fallbacks, []net.IPAddr{v6})
+
+	primaries, fallbacks = partitionAddrs(addrs, IPv6Only)
+	asserts.Equal(primaries, []net.IPAddr{v6})
+	asserts.Nil(fallbacks)
+
+	primaries, fallbacks = partitionAddrs([]net.IPAddr{v4}, PreferIPv6)
+	asserts.Equal(primaries, []net.IPAddr{v4})
+	asserts.Nil(fallbacks)
+
+	primaries, _ = partitionAddrs([]net.IPAddr{v4}, IPv6Only)
+	asserts.Empty(primaries)
+}
+
+func TestSession_SetIPFamily(t *testing.T) {
+	asserts := assert.New(t)
+
+	// the server only listens on IPv4
+	ser := RunServer()
+	defer ser.Close()
+	_, port, _ := net.SplitHostPort(ser.Listener.Addr().String())
+
+	resolver := &staticResolver{addrs: []net.IPAddr{
+		{IP: net.IPv6loopback},
+		{IP: net.IPv4(127, 0, 0, 1)},
+	}}
+	session := NewSession().SetResolver(resolver).EnableTrace()
+
+	// IPv6 fails, IPv4 is raced as the fallback
+	session.SetIPFamily(PreferIPv6, 50*time.Millisecond)
+	resp, err := session.Get("http://dual.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "quick")
+	asserts.Equal(resp.TraceInfo().RemoteAddrFamily, "ipv4")
+
+	// without Happy Eyeballs, IPv4 is dialed once IPv6 failed
+	var (
+		mu    sync.Mutex
+		dials []string
+	)
+	d := &net.Dialer{}
+	session.SetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dials = append(dials, addr)
+		mu.Unlock()
+		if strings.HasPrefix(addr, "[") {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return d.DialContext(ctx, network, addr)
+	})
+	session.SetIPFamily(PreferIPv6, -1)
+	start := time.Now()
+	resp, err = session.Get("http://serial.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "quick")
+	asserts.True(time.Since(start) >= 100*time.Millisecond)
+	asserts.Equal(dials, []string{"[::1]:" + port, "127.0.0.1:" + port})
+	session.SetDialer(nil)
+
+	session.SetIPFamily(IPv6Only, 0)
+	_, err = session.Get("http://v6.test:"+port, OptionTimeout(time.Second))
+	asserts.NotNil(err)
+}
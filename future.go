@@ -0,0 +1,95 @@
+package quick
+
+import (
+	"context"
+)
+
+// Future is the handle of a request sent asynchronously, see Session.SuckAsync.
+type Future struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	resp   *Response
+	err    error
+}
+
+// SuckAsync sends req in a new goroutine and returns its Future.
+// req itself is left untouched, like with SuckAll: a copy is sent, made
+// before SuckAsync returns, so req may be modified or sent again right away.
+//
+//	f1 := session.SuckAsync(quick.NewRequest().SetUrl("http://example.com/a"))
+//	f2 := session.SuckAsync(quick.NewRequest().SetUrl("http://example.com/b"))
+//	responses, err := quick.WaitAll(f1, f2)
+func (session *Session) SuckAsync(req *Request, ops ...OptionFunc) *Future {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Future{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	r := req.Copy()
+	go func() {
+		defer close(f.done)
+		defer cancel()
+		result := session.suckOne(ctx, 0, r, ops)
+		f.resp, f.err = result.Response, result.Err
+	}()
+	return f
+}
+
+// Wait waits for the request to complete and returns its result.
+func (f *Future) Wait() (*Response, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// Done returns a channel closed when the request completes.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Cancel cancels the request. Wait then returns its error,
+// unless it already completed.
+func (f *Future) Cancel() {
+	f.cancel()
+}
+
+// WaitAll waits for all futures to complete and returns their responses,
+// in order, and the error of the first failed future.
+func WaitAll(futures ...*Future) ([]*Response, error) {
+	var (
+		responses = make([]*Response, len(futures))
+		firstErr  error
+	)
+	for i, f := range futures {
+		resp, err := f.Wait()
+		responses[i] = resp
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return responses, firstErr
+}
+
+// WaitAny waits for the first of futures to complete and returns its index
+// and result. The others are left running, Cancel them if not needed.
+// It returns -1 when futures is empty.
+func WaitAny(futures ...*Future) (int, *Response, error) {
+	if len(futures) == 0 {
+		return -1, nil, nil
+	}
+
+	first := make(chan int, len(futures))
+	stop := make(chan struct{})
+	defer close(stop)
+	for i, f := range futures {
+		go func(i int, f *Future) {
+			select {
+			case <-f.done:
+				first <- i
+			case <-stop:
+			}
+		}(i, f)
+	}
+
+	i := <-first
+	return i, futures[i].resp, futures[i].err
+}
@@ -0,0 +1,68 @@
+package quick
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSession_SuckAsync(t *testing.T) {
+	asserts := assert.New(t)
+
+	var inFlight, maxInFlight int32
+	ser := RunBatchServer(&inFlight, &maxInFlight)
+	defer ser.Close()
+
+	session := NewSession()
+	f1 := session.SuckAsync(NewRequest().SetUrl(ser.URL + "/50"))
+	f2 := session.SuckAsync(NewRequest().SetUrl(ser.URL + "/0"))
+
+	i, resp, err := WaitAny(f1, f2)
+	asserts.Equal(i, 1)
+	asserts.NoError(err)
+	asserts.Equal(resp.Body.String(), "0")
+
+	select {
+	case <-f1.Done():
+		t.Fatal("f1 completed before f2")
+	default:
+	}
+
+	responses, err := WaitAll(f1, f2)
+	asserts.NoError(err)
+	asserts.Equal(responses[0].Body.String(), "50")
+	asserts.Equal(responses[1].Body.String(), "0")
+
+	// cancellation
+	f3 := session.SuckAsync(NewRequest().SetUrl(ser.URL + "/1000"))
+	start := time.Now()
+	f3.Cancel()
+	_, err = f3.Wait()
+	asserts.Error(err)
+	asserts.True(time.Since(start) < 500*time.Millisecond)
+
+	responses, err = WaitAll(f2, f3)
+	asserts.Len(responses, 2)
+	asserts.Error(err)
+
+	i, _, _ = WaitAny()
+	asserts.Equal(i, -1)
+
+	// the request may be reused right away
+	req := NewRequest().SetUrl(ser.URL + "/10")
+	f4 := session.SuckAsync(req, OptionHeaderSingle("X-Async", "1"))
+	req.SetUrl(ser.URL+"/20").SetHeaderSingle("X-Async", "2")
+	f5 := session.SuckAsync(req)
+	responses, err = WaitAll(f4, f5)
+	if asserts.NoError(err) {
+		asserts.Equal("10", responses[0].Body.String())
+		asserts.Equal("20", responses[1].Body.String())
+	}
+
+	// the request context is kept
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = session.SuckAsync(NewRequestWithContext(ctx).SetUrl(ser.URL + "/0")).Wait()
+	asserts.Error(err)
+}
@@ -0,0 +1,120 @@
+package quick
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// hedge is the hedging setting of a request, see OptionHedge.
+type hedge struct {
+	delay    time.Duration
+	maxExtra int
+}
+
+// hedgeable reports whether req can be sent several times.
+func (h *hedge) hedgeable(req *Request) bool {
+	if h == nil || h.maxExtra <= 0 || req.Body != nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+type hedgeResult struct {
+	attempt int
+	req     *Request
+	resp    *Response
+	err     error
+}
+
+// win returns the response of the attempt, with its trace copied to req.
+func (r *hedgeResult) win(req *Request) (*Response, error) {
+	r.resp.attempt = r.attempt
+	req.clientTrace = r.req.clientTrace
+	return r.resp, nil
+}
+
+// suckHedged sends req, and up to maxExtra copies of it each delay while no
+// attempt succeeded. The first attempt answering without a 5xx status wins, the
+// others are cancelled. A failed attempt starts the next one at once; when all
+// fail, the last 5xx response, if any, is returned. Options are already applied.
+func (session *Session) suckHedged(req *Request) (*Response, error) {
+	h := req.hedge
+	parent := req.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	results := make(chan hedgeResult, h.maxExtra+1)
+	send := func(attempt int) {
+		// a copy per attempt, made before it runs: sending modifies the request
+		r := req.Copy()
+		r.hedge = nil
+		// attempts would otherwise wait for each other
+		r.ctx = context.WithValue(ctx, noDedupKey, true)
+		go func() {
+			resp, err := session.Suck(r)
+			results <- hedgeResult{attempt: attempt, req: r, resp: resp, err: err}
+		}()
+	}
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	resetTimer := func() {
+		// a tick left in the channel would start the next attempt at once
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(h.delay)
+	}
+
+	var (
+		started = 1
+		running = 1
+		lastErr error
+		// the last 5xx response, returned when no attempt succeeds
+		lastResp *hedgeResult
+	)
+	send(started)
+	for {
+		select {
+		case <-timer.C:
+			if started <= h.maxExtra {
+				started++
+				running++
+				send(started)
+				resetTimer()
+			}
+		case result := <-results:
+			running--
+			if result.err == nil && result.resp.StatusCode < http.StatusInternalServerError {
+				return result.win(req)
+			}
+			if result.err == nil {
+				lastResp = &result
+			} else {
+				lastErr = result.err
+			}
+			if started <= h.maxExtra && ctx.Err() == nil {
+				started++
+				running++
+				send(started)
+				resetTimer()
+			} else if running == 0 {
+				if lastResp != nil {
+					return lastResp.win(req)
+				}
+				return nil, lastErr
+			}
+		}
+	}
+}
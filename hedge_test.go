@@ -0,0 +1,137 @@
+package quick
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSession_Hedge(t *testing.T) {
+	asserts := assert.New(t)
+
+	var calls, cancelled int32
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 1 || r.URL.Query().Get("slow") != "" {
+			select {
+			case <-r.Context().Done():
+				atomic.AddInt32(&cancelled, 1)
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		_, _ = w.Write([]byte(strconv.Itoa(int(n))))
+	}))
+	defer ser.Close()
+
+	session := NewSession()
+	start := time.Now()
+	resp, err := session.Get(ser.URL, OptionHedge(20*time.Millisecond, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "2")
+	asserts.Equal(resp.TraceInfo().RequestAttempt, 2)
+	asserts.True(time.Since(start) < 500*time.Millisecond)
+
+	// the slow attempt is cancelled
+	for i := 0; i < 100 && atomic.LoadInt32(&cancelled) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	asserts.Equal(atomic.LoadInt32(&cancelled), int32(1))
+
+	// at most maxExtra duplicates
+	atomic.StoreInt32(&calls, 1)
+	resp, err = session.Get(ser.URL+"?slow=1", OptionHedge(10*time.Millisecond, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(atomic.LoadInt32(&calls), int32(4))
+	asserts.Equal(resp.TraceInfo().RequestAttempt, 1)
+
+	// requests with body are not hedged
+	atomic.StoreInt32(&calls, 1)
+	resp, err = session.Post(ser.URL, OptionBody("quick"), OptionHedge(10*time.Millisecond, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "2")
+	asserts.Equal(resp.TraceInfo().RequestAttempt, 0)
+}
+
+func TestSession_Hedge_ProxyPool(t *testing.T) {
+	asserts := assert.New(t)
+
+	var calls int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	pool, err := NewProxyPool(ProxyRoundRobin, proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MaxFailures = 1
+	session := NewSession().SetProxyPool(pool)
+	resp, err := session.Get("http://example.com/", OptionHedge(20*time.Millisecond, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal("proxied", resp.Body.String())
+
+	// the cancelled attempt is not a proxy failure
+	time.Sleep(50 * time.Millisecond)
+	stats := pool.Stats()
+	asserts.True(stats[0].Healthy)
+	asserts.Equal(int64(0), stats[0].Failures)
+	asserts.Equal(int64(1), stats[0].Successes)
+}
+
+func TestSession_Hedge_ServerError(t *testing.T) {
+	asserts := assert.New(t)
+
+	var calls int32
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("fail") != "" || n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write([]byte(strconv.Itoa(int(n))))
+	}))
+	defer ser.Close()
+
+	// a 5xx response starts the next attempt, which wins
+	session := NewSession()
+	req := NewRequest().SetUrl(ser.URL).SetHedge(time.Second, 2).EnableTrace()
+	resp, err := session.Suck(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(http.StatusOK, resp.StatusCode)
+	asserts.Equal("2", resp.Body.String())
+	asserts.Equal(2, resp.TraceInfo().RequestAttempt)
+	// the request has the trace of the winning attempt
+	ti := resp.TraceInfo()
+	asserts.NotNil(req.TraceInfo().RemoteAddr)
+	ti.RequestAttempt = 0
+	asserts.Equal(ti, req.TraceInfo())
+
+	// when all attempts fail, the last 5xx response is returned
+	atomic.StoreInt32(&calls, 0)
+	resp, err = session.Get(ser.URL+"?fail=1", OptionHedge(time.Second, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	asserts.Equal("3", resp.Body.String())
+	asserts.Equal(3, resp.TraceInfo().RequestAttempt)
+}
@@ -0,0 +1,99 @@
+package quick
+
+import (
+	"context"
+	"crypto/tls"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+)
+
+// configureHTTP2 applies the HTTP/2 options of SessionOptions to transport.
+// The h2c transport registered for "http" URLs is returned when EnableH2C is set.
+func configureHTTP2(transport *http.Transport, options *SessionOptions) (*http2.Transport, error) {
+	if options.ForceHTTP1 {
+		// a non-nil, empty TLSNextProto disables HTTP/2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	} else if options.EnableHTTP2 {
+		t2, err := http2.ConfigureTransports(transport)
+		if err != nil {
+			return nil, err
+		}
+		applyHTTP2Options(t2, options)
+	}
+
+	if !options.EnableH2C {
+		return nil, nil
+	}
+	t2 := newH2CTransport(transport.DialContext)
+	applyHTTP2Options(t2, options)
+	transport.RegisterProtocol("http", t2)
+	return t2, nil
+}
+
+// newH2CTransport returns an http2.Transport sending "http" URLs as h2c
+// over the connections of dial.
+func newH2CTransport(dial DialFunc) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+}
+
+// applyHTTP2Options copies the HTTP2* settings to an http2.Transport.
+func applyHTTP2Options(t2 *http2.Transport, options *SessionOptions) {
+	t2.ReadIdleTimeout = options.HTTP2ReadIdleTimeout
+	t2.PingTimeout = options.HTTP2PingTimeout
+	t2.MaxReadFrameSize = options.HTTP2MaxReadFrameSize
+}
+
+// socksH2C is the transport of a SOCKS proxy when h2c is enabled. The h2c
+// transport registered on the session transport is copied along by Clone and
+// would dial directly, so "http" URLs are sent by an h2c transport of its own.
+type socksH2C struct {
+	*http.Transport
+	h2c *http2.Transport
+}
+
+func (t *socksH2C) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" && !websocketUpgrade(req) {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+func (t *socksH2C) CloseIdleConnections() {
+	t.Transport.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
+
+// http1Transport returns the clone of the session *http.Transport sending
+// WebSocket handshakes: HTTP/2 can not upgrade a connection, so the clone
+// offers only http/1.1 through ALPN and does not send "http" URLs as h2c.
+// nil is returned when the session RoundTripper neither is nor wraps its
+// *http.Transport.
+func (session *Session) http1Transport() *http.Transport {
+	session.proxyMu.Lock()
+	defer session.proxyMu.Unlock()
+
+	base := session.transport
+	if base == nil || !wrapsTransport(session.roundTripper, base) {
+		return nil
+	}
+	if session.http1 != nil {
+		return session.http1
+	}
+
+	t := base.Clone()
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	session.http1 = t
+	return t
+}
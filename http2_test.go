@@ -0,0 +1,95 @@
+package quick
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func protoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+}
+
+func TestSession_EnableHTTP2(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := httptest.NewUnstartedServer(protoHandler())
+	ser.EnableHTTP2 = true
+	ser.StartTLS()
+	defer ser.Close()
+
+	opts := DefaultSessionOptions()
+	opts.EnableHTTP2 = true
+	opts.HTTP2ReadIdleTimeout = 10 * time.Second
+	opts.HTTP2MaxReadFrameSize = 1 << 20
+	session := NewSession(opts).InsecureSkipVerify(true)
+
+	resp, err := session.Get(ser.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Proto, "HTTP/2.0")
+	asserts.Equal(resp.Body.String(), "HTTP/2.0")
+}
+
+func TestSession_ForceHTTP1(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := httptest.NewUnstartedServer(protoHandler())
+	ser.EnableHTTP2 = true
+	ser.StartTLS()
+	defer ser.Close()
+
+	opts := DefaultSessionOptions()
+	opts.ForceHTTP1 = true
+	opts.EnableHTTP2 = true
+	session := NewSession(opts).InsecureSkipVerify(true)
+
+	resp, err := session.Get(ser.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Proto, "HTTP/1.1")
+}
+
+func TestSession_EnableH2C(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := httptest.NewServer(h2c.NewHandler(protoHandler(), &http2.Server{}))
+	defer ser.Close()
+
+	opts := DefaultSessionOptions()
+	opts.EnableH2C = true
+
+	resp, err := NewSession(opts).Get(ser.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Proto, "HTTP/2.0")
+	asserts.Equal(resp.Body.String(), "HTTP/2.0")
+}
+
+func TestSession_EnableH2C_SOCKS(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := httptest.NewServer(h2c.NewHandler(protoHandler(), &http2.Server{}))
+	defer ser.Close()
+
+	proxy := RunSocksProxy(t, ser.Listener.Addr().String(), "", "")
+	opts := DefaultSessionOptions()
+	opts.EnableH2C = true
+
+	// h2c connections are dialed through the SOCKS proxy too
+	resp, err := NewSession(opts).Get(ser.URL, OptionProxy(proxy.URL("socks5", nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "HTTP/2.0")
+	asserts.Len(proxy.Requested(), 1)
+}
@@ -0,0 +1,959 @@
+// Package pacscript interprets the subset of JavaScript proxy auto-config
+// files are usually written in: function declarations, var, assignments,
+// if/else, return, string, number and boolean literals, the operators
+// || && ! == != === !== < > <= >= + - * / % and ?:, and the string
+// methods toLowerCase, toUpperCase, indexOf, substring and length. The PAC
+// functions are provided by the caller.
+//
+// Other syntax, e.g. loops, arrays, objects or regular expression literals,
+// fails to parse with an error naming its line. A call is evaluated in at
+// most Timeout and 64 nested calls.
+package pacscript
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeout bounds the evaluation of a call.
+const Timeout = time.Second
+
+// Func is a function provided to scripts, called with the evaluated arguments.
+type Func func(ctx context.Context, args []interface{}) (interface{}, error)
+
+// Script is a parsed script.
+type Script struct {
+	funcs   map[string]*function
+	globals []statement
+}
+
+// Parse parses a script.
+func Parse(src string) (*Script, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	script := &Script{funcs: make(map[string]*function)}
+	for !p.done() {
+		if p.peekIdent("function") {
+			fn, err := p.function()
+			if err != nil {
+				return nil, err
+			}
+			script.funcs[fn.name] = fn
+			continue
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		script.globals = append(script.globals, stmt)
+	}
+	return script, nil
+}
+
+// Defines reports whether the script declares the function name.
+func (s *Script) Defines(name string) bool {
+	_, ok := s.funcs[name]
+	return ok
+}
+
+// Call runs the global statements of the script, then calls its function
+// name with args. funcs are the functions provided to the script.
+func (s *Script) Call(ctx context.Context, funcs map[string]Func, name string, args ...interface{}) (interface{}, error) {
+	fn, ok := s.funcs[name]
+	if !ok {
+		return nil, fmt.Errorf("pac: %s is not defined", name)
+	}
+	ev := &evaluator{ctx: ctx, script: s, funcs: funcs, deadline: time.Now().Add(Timeout)}
+	global := &env{vars: make(map[string]interface{})}
+	for _, stmt := range s.globals {
+		if _, _, err := ev.exec(stmt, global); err != nil {
+			return nil, err
+		}
+	}
+	return ev.call(fn, args, global)
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// tokenizer
+//_______________________________________________________________________
+
+const (
+	tokenIdent = iota
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind  int
+	value string
+	line  int
+}
+
+var puncts = []string{"===", "!==", "==", "!=", "&&", "||", "<=", ">=", "(", ")", "{", "}", ";", ",", ".", "!", "+", "-", "*", "/", "%", "<", ">", "=", "?", ":"}
+
+func tokenize(src string) ([]token, error) {
+	tokens := make([]token, 0)
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("pac: line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(src[j])
+					}
+					continue
+				}
+				if src[j] == '\n' {
+					break
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) || src[j] != c {
+				return nil, fmt.Errorf("pac: line %d: unterminated string", line)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: sb.String(), line: line})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, value: src[i:j], line: line})
+			i = j
+		case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: src[i:j], line: line})
+			i = j
+		default:
+			matched := false
+			for _, punct := range puncts {
+				if strings.HasPrefix(src[i:], punct) {
+					tokens = append(tokens, token{kind: tokenPunct, value: punct, line: line})
+					i += len(punct)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("pac: line %d: unsupported character %q", line, c)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// parser
+//_______________________________________________________________________
+
+type statement interface{}
+type expression interface{}
+
+type (
+	function struct {
+		name   string
+		params []string
+		body   []statement
+	}
+	blockStmt struct{ stmts []statement }
+	ifStmt    struct {
+		cond      expression
+		then, els statement
+	}
+	returnStmt struct{ value expression }
+	varStmt    struct {
+		name    string
+		value   expression
+		declare bool
+	}
+	exprStmt struct{ expr expression }
+
+	literal   struct{ value interface{} }
+	identExpr struct{ name string }
+	unaryExpr struct {
+		op string
+		x  expression
+	}
+	binaryExpr struct {
+		op   string
+		x, y expression
+	}
+	condExpr struct {
+		cond, then, els expression
+	}
+	callExpr struct {
+		name string
+		args []expression
+	}
+	methodCallExpr struct {
+		recv expression
+		name string
+		args []expression
+	}
+	propertyExpr struct {
+		recv expression
+		name string
+	}
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekPunct(value string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.value == value
+}
+
+func (p *parser) peekIdent(value string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && t.value == value
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if !p.done() {
+		line = p.peek().line
+	} else if len(p.tokens) > 0 {
+		line = p.tokens[len(p.tokens)-1].line
+	}
+	return fmt.Errorf("pac: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expectPunct(value string) error {
+	if !p.peekPunct(value) {
+		return p.errorf("expected %q", value)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != tokenIdent {
+		return "", p.errorf("expected identifier")
+	}
+	p.pos++
+	return t.value, nil
+}
+
+func (p *parser) function() (*function, error) {
+	p.pos++ // function
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	fn := &function{name: name}
+	for !p.peekPunct(")") {
+		param, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		fn.params = append(fn.params, param)
+		if !p.peekPunct(")") {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p.pos++ // )
+	block, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	fn.body = block.stmts
+	return fn, nil
+}
+
+func (p *parser) block() (*blockStmt, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	block := &blockStmt{}
+	for !p.peekPunct("}") {
+		if p.done() {
+			return nil, p.errorf("expected \"}\"")
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		block.stmts = append(block.stmts, stmt)
+	}
+	p.pos++ // }
+	return block, nil
+}
+
+func (p *parser) statement() (statement, error) {
+	switch {
+	case p.peekPunct("{"):
+		return p.block()
+	case p.peekPunct(";"):
+		p.pos++
+		return &blockStmt{}, nil
+	case p.peekIdent("if"):
+		p.pos++
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		then, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmt := &ifStmt{cond: cond, then: then}
+		if p.peekIdent("else") {
+			p.pos++
+			if stmt.els, err = p.statement(); err != nil {
+				return nil, err
+			}
+		}
+		return stmt, nil
+	case p.peekIdent("return"):
+		p.pos++
+		stmt := &returnStmt{}
+		if !p.peekPunct(";") && !p.peekPunct("}") {
+			value, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.value = value
+		}
+		p.semicolon()
+		return stmt, nil
+	case p.peekIdent("for"), p.peekIdent("while"), p.peekIdent("do"), p.peekIdent("switch"),
+		p.peekIdent("try"), p.peekIdent("throw"), p.peekIdent("new"):
+		return nil, p.errorf("unsupported statement %q", p.peek().value)
+	case p.peekIdent("var"):
+		p.pos++
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		stmt := &varStmt{name: name, declare: true}
+		if p.peekPunct("=") {
+			p.pos++
+			if stmt.value, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		p.semicolon()
+		return stmt, nil
+	}
+
+	// assignment or expression
+	if t := p.peek(); t.kind == tokenIdent && p.pos+1 < len(p.tokens) {
+		if next := p.tokens[p.pos+1]; next.kind == tokenPunct && next.value == "=" {
+			p.pos += 2
+			value, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			p.semicolon()
+			return &varStmt{name: t.value, value: value}, nil
+		}
+	}
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.semicolon()
+	return &exprStmt{expr: expr}, nil
+}
+
+// semicolon consumes an optional statement terminator.
+func (p *parser) semicolon() {
+	if p.peekPunct(";") {
+		p.pos++
+	}
+}
+
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "===", "!=="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) expr() (expression, error) {
+	cond, err := p.binary(0)
+	if err != nil || !p.peekPunct("?") {
+		return cond, err
+	}
+	p.pos++
+	then, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{cond: cond, then: then, els: els}, nil
+}
+
+func (p *parser) binary(level int) (expression, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokenPunct || !contains(precedence[level], t.value) {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: t.value, x: x, y: y}
+	}
+}
+
+func (p *parser) unary() (expression, error) {
+	if p.peekPunct("!") || p.peekPunct("-") {
+		op := p.peek().value
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: op, x: x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (expression, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekPunct(".") {
+		p.pos++
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekPunct("(") {
+			x = &propertyExpr{recv: x, name: name}
+			continue
+		}
+		args, err := p.args()
+		if err != nil {
+			return nil, err
+		}
+		x = &methodCallExpr{recv: x, name: name, args: args}
+	}
+	return x, nil
+}
+
+func (p *parser) primary() (expression, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenString:
+		p.pos++
+		return &literal{value: t.value}, nil
+	case tokenNumber:
+		p.pos++
+		n, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", t.value)
+		}
+		return &literal{value: n}, nil
+	case tokenIdent:
+		p.pos++
+		switch t.value {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null", "undefined":
+			return &literal{value: nil}, nil
+		}
+		if !p.peekPunct("(") {
+			return &identExpr{name: t.value}, nil
+		}
+		args, err := p.args()
+		if err != nil {
+			return nil, err
+		}
+		return &callExpr{name: t.value, args: args}, nil
+	case tokenPunct:
+		if t.value == "(" {
+			p.pos++
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expectPunct(")")
+		}
+	}
+	if p.done() {
+		return nil, p.errorf("unexpected end of script")
+	}
+	return nil, p.errorf("unsupported or unexpected token %q", t.value)
+}
+
+func (p *parser) args() ([]expression, error) {
+	p.pos++ // (
+	args := make([]expression, 0)
+	for !p.peekPunct(")") {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.peekPunct(")") {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p.pos++ // )
+	return args, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// evaluator
+//_______________________________________________________________________
+
+type env struct {
+	vars   map[string]interface{}
+	parent *env
+}
+
+func (s *env) lookup(name string) (interface{}, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if v, ok := scope.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (s *env) assign(name string, value interface{}) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if _, ok := scope.vars[name]; ok || scope.parent == nil {
+			scope.vars[name] = value
+			return
+		}
+	}
+}
+
+type evaluator struct {
+	ctx      context.Context
+	script   *Script
+	funcs    map[string]Func
+	depth    int
+	deadline time.Time
+}
+
+// exec runs a statement, reporting whether it returned and the returned value.
+func (ev *evaluator) exec(stmt statement, scope *env) (interface{}, bool, error) {
+	switch s := stmt.(type) {
+	case *blockStmt:
+		for _, stmt := range s.stmts {
+			if v, returned, err := ev.exec(stmt, scope); err != nil || returned {
+				return v, returned, err
+			}
+		}
+	case *ifStmt:
+		cond, err := ev.eval(s.cond, scope)
+		if err != nil {
+			return nil, false, err
+		}
+		if truthy(cond) {
+			return ev.exec(s.then, scope)
+		} else if s.els != nil {
+			return ev.exec(s.els, scope)
+		}
+	case *returnStmt:
+		if s.value == nil {
+			return nil, true, nil
+		}
+		v, err := ev.eval(s.value, scope)
+		return v, true, err
+	case *varStmt:
+		var v interface{}
+		if s.value != nil {
+			var err error
+			if v, err = ev.eval(s.value, scope); err != nil {
+				return nil, false, err
+			}
+		}
+		if s.declare {
+			scope.vars[s.name] = v
+		} else {
+			scope.assign(s.name, v)
+		}
+	case *exprStmt:
+		_, err := ev.eval(s.expr, scope)
+		return nil, false, err
+	}
+	return nil, false, nil
+}
+
+func (ev *evaluator) eval(expr expression, scope *env) (interface{}, error) {
+	switch e := expr.(type) {
+	case *literal:
+		return e.value, nil
+	case *identExpr:
+		v, ok := scope.lookup(e.name)
+		if !ok {
+			return nil, fmt.Errorf("pac: %s is not defined", e.name)
+		}
+		return v, nil
+	case *unaryExpr:
+		x, err := ev.eval(e.x, scope)
+		if err != nil {
+			return nil, err
+		}
+		if e.op == "!" {
+			return !truthy(x), nil
+		}
+		return -Number(x), nil
+	case *binaryExpr:
+		return ev.binary(e, scope)
+	case *condExpr:
+		cond, err := ev.eval(e.cond, scope)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return ev.eval(e.then, scope)
+		}
+		return ev.eval(e.els, scope)
+	case *callExpr:
+		args, err := ev.evalArgs(e.args, scope)
+		if err != nil {
+			return nil, err
+		}
+		if fn, ok := ev.script.funcs[e.name]; ok {
+			return ev.call(fn, args, scope)
+		}
+		if fn, ok := ev.funcs[e.name]; ok {
+			return fn(ev.ctx, args)
+		}
+		return nil, fmt.Errorf("pac: %s is not defined", e.name)
+	case *methodCallExpr:
+		recv, err := ev.eval(e.recv, scope)
+		if err != nil {
+			return nil, err
+		}
+		args, err := ev.evalArgs(e.args, scope)
+		if err != nil {
+			return nil, err
+		}
+		return callMethod(recv, e.name, args)
+	case *propertyExpr:
+		recv, err := ev.eval(e.recv, scope)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := recv.(string); ok && e.name == "length" {
+			return float64(len(s)), nil
+		}
+		return nil, nil
+	}
+	return nil, errors.New("pac: unsupported expression")
+}
+
+func (ev *evaluator) binary(e *binaryExpr, scope *env) (interface{}, error) {
+	x, err := ev.eval(e.x, scope)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit operators return one of their operands
+	switch e.op {
+	case "||":
+		if truthy(x) {
+			return x, nil
+		}
+		return ev.eval(e.y, scope)
+	case "&&":
+		if !truthy(x) {
+			return x, nil
+		}
+		return ev.eval(e.y, scope)
+	}
+
+	y, err := ev.eval(e.y, scope)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==", "===":
+		return equal(x, y), nil
+	case "!=", "!==":
+		return !equal(x, y), nil
+	case "+":
+		xs, xok := x.(string)
+		ys, yok := y.(string)
+		if xok || yok {
+			if !xok {
+				xs = String(x)
+			}
+			if !yok {
+				ys = String(y)
+			}
+			return xs + ys, nil
+		}
+		return Number(x) + Number(y), nil
+	case "-":
+		return Number(x) - Number(y), nil
+	case "*":
+		return Number(x) * Number(y), nil
+	case "/":
+		return Number(x) / Number(y), nil
+	case "%":
+		return math.Mod(Number(x), Number(y)), nil
+	}
+
+	xs, xok := x.(string)
+	ys, yok := y.(string)
+	if xok && yok {
+		switch e.op {
+		case "<":
+			return xs < ys, nil
+		case ">":
+			return xs > ys, nil
+		case "<=":
+			return xs <= ys, nil
+		default:
+			return xs >= ys, nil
+		}
+	}
+	xn, yn := Number(x), Number(y)
+	switch e.op {
+	case "<":
+		return xn < yn, nil
+	case ">":
+		return xn > yn, nil
+	case "<=":
+		return xn <= yn, nil
+	default:
+		return xn >= yn, nil
+	}
+}
+
+func (ev *evaluator) evalArgs(exprs []expression, scope *env) ([]interface{}, error) {
+	args := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		v, err := ev.eval(expr, scope)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+func (ev *evaluator) call(fn *function, args []interface{}, parent *env) (interface{}, error) {
+	ev.depth++
+	defer func() { ev.depth-- }()
+	if ev.depth > 64 {
+		return nil, errors.New("pac: maximum call depth exceeded")
+	}
+	if time.Now().After(ev.deadline) {
+		return nil, errors.New("pac: evaluation timeout")
+	}
+	if err := ev.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// functions see the globals, not the caller variables
+	for parent.parent != nil {
+		parent = parent.parent
+	}
+	scope := &env{vars: make(map[string]interface{}), parent: parent}
+	for i, param := range fn.params {
+		var v interface{}
+		if i < len(args) {
+			v = args[i]
+		}
+		scope.vars[param] = v
+	}
+	v, _, err := ev.exec(&blockStmt{stmts: fn.body}, scope)
+	return v, err
+}
+
+// callMethod calls a string method.
+func callMethod(recv interface{}, name string, args []interface{}) (interface{}, error) {
+	s, ok := recv.(string)
+	if !ok {
+		return nil, fmt.Errorf("pac: %s is not a function", name)
+	}
+	switch name {
+	case "toLowerCase":
+		return strings.ToLower(s), nil
+	case "toUpperCase":
+		return strings.ToUpper(s), nil
+	case "indexOf":
+		if len(args) == 0 {
+			return float64(-1), nil
+		}
+		return float64(strings.Index(s, String(args[0]))), nil
+	case "substring":
+		start, end := 0, len(s)
+		if len(args) > 0 {
+			start = clamp(int(Number(args[0])), len(s))
+		}
+		if len(args) > 1 {
+			end = clamp(int(Number(args[1])), len(s))
+		}
+		if start > end {
+			start, end = end, start
+		}
+		return s[start:end], nil
+	}
+	return nil, fmt.Errorf("pac: %s is not a function", name)
+}
+
+func clamp(n, max int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case float64:
+		return t != 0
+	}
+	return true
+}
+
+// Number converts v to a number like JavaScript does, 0 when it is not one.
+func Number(v interface{}) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case bool:
+		if t {
+			return 1
+		}
+	case string:
+		n, _ := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return n
+	}
+	return 0
+}
+
+// String converts v to a string like JavaScript does.
+func String(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func equal(x, y interface{}) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	switch xt := x.(type) {
+	case string:
+		if yt, ok := y.(string); ok {
+			return xt == yt
+		}
+	case bool:
+		if yt, ok := y.(bool); ok {
+			return xt == yt
+		}
+	}
+	return Number(x) == Number(y)
+}
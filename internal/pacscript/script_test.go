@@ -0,0 +1,138 @@
+package pacscript
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse_Error(t *testing.T) {
+	asserts := assert.New(t)
+
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`function f() { return "x"; `, `line 1: expected "}"`},
+		{`function f( { }`, "line 1: expected identifier"},
+		{"var s = 'open;", "line 1: unterminated string"},
+		{"/* comment", "line 1: unterminated comment"},
+		{"var x = 1;\nvar y = #;", `line 2: unsupported character '#'`},
+		{"for (var i = 0; i < 2; i++) {}", `line 1: unsupported statement "for"`},
+		{"function f() {\n  while (true) {}\n}", `line 2: unsupported statement "while"`},
+		{"var list = [1, 2];", `unsupported character '['`},
+		{"var x = 1 + );", `unsupported or unexpected token ")"`},
+		{"var x = a ? b;", `expected ":"`},
+		{"var x = (1 + 2;", `expected ")"`},
+		{"var x = 1 +", "unexpected end of script"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.src)
+		if asserts.Error(err, test.src) {
+			asserts.Contains(err.Error(), test.err, test.src)
+		}
+	}
+}
+
+func TestScript_Call(t *testing.T) {
+	asserts := assert.New(t)
+
+	tests := []struct {
+		expr   string
+		result interface{}
+	}{
+		{`"a" + "b" + 1`, "ab1"},
+		{`1 + 2 * 3 - 4 / 2`, float64(5)},
+		{`(1 + 2) * 3`, float64(9)},
+		{`7 % 4`, float64(3)},
+		{`-arg - 1`, float64(-2)},
+		{`!arg`, false},
+		{`arg > 1 ? "big" : arg == 1 ? "one" : "small"`, "one"},
+		{`"2" == 2 && "b" > "a"`, true},
+		{`null || "default"`, "default"},
+		{`"" && "never"`, ""},
+		{`"Quick".toLowerCase().substring(1, 3)`, "ui"},
+		{`"quick".indexOf("ick") + "quick".length`, float64(7)},
+		{`g + name(arg)`, "global:1"},
+		{`undefined == null`, true},
+	}
+	for _, test := range tests {
+		script, err := Parse(`
+			var g = "global";
+			function name(n) { if (n == 1) return ":1"; else { return ":n"; } }
+			function f(arg) { return ` + test.expr + `; }`)
+		if !asserts.NoError(err, test.expr) {
+			continue
+		}
+		result, err := script.Call(context.Background(), nil, "f", float64(1))
+		if asserts.NoError(err, test.expr) {
+			asserts.Equal(test.result, result, test.expr)
+		}
+	}
+}
+
+func TestScript_CallError(t *testing.T) {
+	asserts := assert.New(t)
+
+	funcs := map[string]Func{
+		"provided": func(ctx context.Context, args []interface{}) (interface{}, error) {
+			return String(args[0]), nil
+		},
+		"slow": func(ctx context.Context, args []interface{}) (interface{}, error) {
+			time.Sleep(Timeout)
+			return nil, nil
+		},
+	}
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`function f() { return missing; }`, "missing is not defined"},
+		{`function f() { return missing(); }`, "missing is not defined"},
+		{`function f() { return (1).toLowerCase(); }`, "toLowerCase is not a function"},
+		{`function f() { return "a".replace("a", "b"); }`, "replace is not a function"},
+		{`function f() { return f(); }`, "maximum call depth exceeded"},
+		{`function f() { slow(); return g(); } function g() { return 1; }`, "evaluation timeout"},
+		{`var x = missing; function f() { return 1; }`, "missing is not defined"},
+		{`function g() { return 1; }`, "f is not defined"},
+	}
+	for _, test := range tests {
+		script, err := Parse(test.src)
+		if !asserts.NoError(err, test.src) {
+			continue
+		}
+		_, err = script.Call(context.Background(), funcs, "f")
+		if asserts.Error(err, test.src) {
+			asserts.Contains(err.Error(), test.err, test.src)
+		}
+	}
+
+	// provided functions get the evaluated arguments
+	script, _ := Parse(`function f(x) { return provided(x + 1); }`)
+	result, err := script.Call(context.Background(), funcs, "f", float64(1))
+	asserts.NoError(err)
+	asserts.Equal("2", result)
+	asserts.True(script.Defines("f"))
+	asserts.False(script.Defines("provided"))
+
+	// the evaluation ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = script.Call(ctx, funcs, "f", float64(1))
+	asserts.Equal(context.Canceled, err)
+}
+
+func TestConversions(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Equal(float64(12), Number(" 12 "))
+	asserts.Equal(float64(1), Number(true))
+	asserts.Equal(float64(0), Number("abc"))
+	asserts.Equal(float64(0), Number(nil))
+	asserts.Equal("1.5", String(1.5))
+	asserts.Equal("null", String(nil))
+	asserts.Equal("true", String(true))
+	asserts.True(strings.HasPrefix(String([]int{1}), "["))
+}
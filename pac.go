@@ -0,0 +1,489 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/telanflow/quick/internal/pacscript"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PACRetryAfter is how long a proxy of a PAC script that failed is skipped
+// in favor of the next entries of the script results.
+const PACRetryAfter = 5 * time.Minute
+
+// PAC is a proxy auto-config script.
+//
+// Scripts are evaluated by a small interpreter supporting the subset of
+// JavaScript PAC files are usually written in: function declarations, var,
+// if/else, return, string and boolean expressions, the string methods
+// toLowerCase, toUpperCase, indexOf, substring and length, and the PAC
+// functions isPlainHostName, dnsDomainIs, localHostOrDomainIs, isResolvable,
+// isInNet, dnsResolve, convert_addr, myIpAddress, dnsDomainLevels,
+// shExpMatch, weekdayRange, dateRange and timeRange.
+type PAC struct {
+	// Resolver resolves the hosts of the DNS functions, the system
+	// resolver when nil. Results are cached for DefaultResolverTTL.
+	Resolver Resolver
+
+	script *pacscript.Script
+	funcs  map[string]pacscript.Func
+	now    func() time.Time
+
+	mu     sync.Mutex
+	hosts  map[string]pacHost   // resolved hosts
+	failed map[string]time.Time // failed proxies
+}
+
+// pacHost is a cached host resolution, ip is nil for unresolvable hosts.
+type pacHost struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// LoadPAC load a PAC script from a local file.
+func LoadPAC(path string) (*PAC, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePAC(string(src))
+}
+
+// ParsePAC parse a PAC script. It must declare FindProxyForURL(url, host).
+func ParsePAC(src string) (*PAC, error) {
+	script, err := pacscript.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if !script.Defines("FindProxyForURL") {
+		return nil, errors.New("pac: FindProxyForURL is not defined")
+	}
+	pac := &PAC{
+		script: script,
+		now:    time.Now,
+		hosts:  make(map[string]pacHost),
+		failed: make(map[string]time.Time),
+	}
+	pac.funcs = map[string]pacscript.Func{
+		"isPlainHostName":     pacStringFunc(func(args []string) interface{} { return !strings.Contains(args[0], ".") }),
+		"dnsDomainIs":         pacStringFunc(pacDNSDomainIs),
+		"localHostOrDomainIs": pacStringFunc(pacLocalHostOrDomainIs),
+		"dnsDomainLevels":     pacStringFunc(func(args []string) interface{} { return float64(strings.Count(args[0], ".")) }),
+		"shExpMatch":          pacStringFunc(func(args []string) interface{} { return pacShExpMatch(args[0], args[1]) }),
+		"convert_addr":        pacStringFunc(pacConvertAddr),
+		"myIpAddress":         pacStringFunc(func([]string) interface{} { return pacMyIPAddress() }),
+		"isResolvable":        pac.isResolvable,
+		"dnsResolve":          pac.dnsResolve,
+		"isInNet":             pac.isInNet,
+		"weekdayRange":        pac.weekdayRange,
+		"dateRange":           pac.dateRange,
+		"timeRange":           pac.timeRange,
+		"alert":               func(context.Context, []interface{}) (interface{}, error) { return nil, nil },
+	}
+	return pac, nil
+}
+
+// FindProxyForURL evaluates the script for u and returns its result,
+// e.g. "PROXY 10.0.0.1:8080; DIRECT". Evaluation and DNS lookups end with ctx.
+func (pac *PAC) FindProxyForURL(ctx context.Context, u *url.URL) (string, error) {
+	v, err := pac.script.Call(ctx, pac.funcs, "FindProxyForURL", u.String(), u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("pac: FindProxyForURL returned %s", pacscript.String(v))
+	}
+	return s, nil
+}
+
+// Proxies returns the proxies of the script result for u in order,
+// nil for DIRECT.
+func (pac *PAC) Proxies(ctx context.Context, u *url.URL) ([]*url.URL, error) {
+	result, err := pac.FindProxyForURL(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return parsePACResult(result)
+}
+
+// Proxy returns the first proxy of the script result for u, nil for DIRECT.
+// Proxies that failed within PACRetryAfter, see ProxyFailed, are skipped
+// unless they all did.
+func (pac *PAC) Proxy(u *url.URL) (*url.URL, error) {
+	return pac.proxy(context.Background(), u)
+}
+
+func (pac *PAC) proxy(ctx context.Context, u *url.URL) (*url.URL, error) {
+	proxies, err := pac.Proxies(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	now := pac.now()
+	pac.mu.Lock()
+	defer pac.mu.Unlock()
+	for _, proxyURL := range proxies {
+		if proxyURL == nil {
+			return nil, nil
+		}
+		if failed, ok := pac.failed[proxyURL.String()]; !ok || now.Sub(failed) >= PACRetryAfter {
+			return proxyURL, nil
+		}
+	}
+	return proxies[0], nil
+}
+
+// ProxyFailed records that a request through proxyURL failed. The proxy is
+// skipped for PACRetryAfter when the script results have other entries.
+func (pac *PAC) ProxyFailed(proxyURL *url.URL) {
+	now := pac.now()
+	pac.mu.Lock()
+	defer pac.mu.Unlock()
+	for key, failed := range pac.failed {
+		if now.Sub(failed) >= PACRetryAfter {
+			delete(pac.failed, key)
+		}
+	}
+	pac.failed[proxyURL.String()] = now
+}
+
+// parsePACResult converts the entries of a PAC result to proxy urls,
+// nil for DIRECT. Entries of an unsupported type are skipped.
+func parsePACResult(result string) ([]*url.URL, error) {
+	var proxies []*url.URL
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.EqualFold(fields[0], "DIRECT") {
+			proxies = append(proxies, nil)
+			continue
+		}
+		if len(fields) != 2 {
+			continue
+		}
+
+		var scheme string
+		switch strings.ToUpper(fields[0]) {
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = ProxySchemeSOCKS5
+		case "SOCKS4":
+			scheme = ProxySchemeSOCKS4
+		default:
+			continue
+		}
+		proxyURL, err := url.Parse(scheme + "://" + fields[1])
+		if err != nil {
+			continue
+		}
+		proxies = append(proxies, proxyURL)
+	}
+	if len(proxies) == 0 && strings.TrimSpace(result) != "" {
+		return nil, fmt.Errorf("pac: invalid result %q", result)
+	}
+	if len(proxies) == 0 {
+		// an empty result is DIRECT
+		proxies = append(proxies, nil)
+	}
+	return proxies, nil
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// PAC functions
+//_______________________________________________________________________
+
+// pacStringFunc adapts a PAC function of string arguments, missing
+// arguments are empty.
+func pacStringFunc(fn func(args []string) interface{}) pacscript.Func {
+	return func(_ context.Context, args []interface{}) (interface{}, error) {
+		strs := make([]string, 2)
+		for i, arg := range args {
+			if i < len(strs) {
+				strs[i] = pacscript.String(arg)
+			}
+		}
+		return fn(strs), nil
+	}
+}
+
+func pacDNSDomainIs(args []string) interface{} {
+	return strings.HasSuffix(strings.ToLower(args[0]), strings.ToLower(args[1]))
+}
+
+func pacLocalHostOrDomainIs(args []string) interface{} {
+	host, hostdom := strings.ToLower(args[0]), strings.ToLower(args[1])
+	if strings.Contains(host, ".") {
+		return host == hostdom
+	}
+	return strings.HasPrefix(hostdom, host+".") || host == hostdom
+}
+
+// pacConvertAddr converts a dotted IPv4 address to a number.
+func pacConvertAddr(args []string) interface{} {
+	ip := net.ParseIP(args[0]).To4()
+	if ip == nil {
+		return float64(0)
+	}
+	return float64(uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]))
+}
+
+func (pac *PAC) isResolvable(ctx context.Context, args []interface{}) (interface{}, error) {
+	return pac.resolve(ctx, pacArg(args, 0)) != nil, nil
+}
+
+func (pac *PAC) dnsResolve(ctx context.Context, args []interface{}) (interface{}, error) {
+	if ip := pac.resolve(ctx, pacArg(args, 0)); ip != nil {
+		return ip.String(), nil
+	}
+	return nil, nil
+}
+
+func (pac *PAC) isInNet(ctx context.Context, args []interface{}) (interface{}, error) {
+	ip := pac.resolve(ctx, pacArg(args, 0))
+	pattern, mask := net.ParseIP(pacArg(args, 1)).To4(), net.ParseIP(pacArg(args, 2)).To4()
+	if ip == nil || pattern == nil || mask == nil {
+		return false, nil
+	}
+	m := net.IPMask(mask)
+	return ip.Mask(m).Equal(pattern.Mask(m)), nil
+}
+
+// resolve returns the IPv4 address of host, nil when it can not be resolved.
+// Results, failures included, are cached for DefaultResolverTTL.
+func (pac *PAC) resolve(ctx context.Context, host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.To4()
+	}
+	host = strings.ToLower(host)
+	now := pac.now()
+	pac.mu.Lock()
+	cached, ok := pac.hosts[host]
+	pac.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.ip
+	}
+
+	resolver := pac.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	addrs, err := resolver.LookupIPAddr(lookupCtx, host)
+	if err != nil && ctx.Err() != nil {
+		// the request is gone, the host may well be resolvable
+		return nil
+	}
+	var ip net.IP
+	for _, addr := range addrs {
+		if ip = addr.IP.To4(); ip != nil {
+			break
+		}
+	}
+
+	pac.mu.Lock()
+	for key, cached := range pac.hosts {
+		if !now.Before(cached.expires) {
+			delete(pac.hosts, key)
+		}
+	}
+	pac.hosts[host] = pacHost{ip: ip, expires: now.Add(DefaultResolverTTL)}
+	pac.mu.Unlock()
+	return ip
+}
+
+var pacWeekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+var pacMonths = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+
+// weekdayRange(wd1 [, wd2] [, "GMT"]) reports whether today is wd1, or
+// between wd1 and wd2 included, e.g. weekdayRange("MON", "FRI").
+func (pac *PAC) weekdayRange(_ context.Context, args []interface{}) (interface{}, error) {
+	now, args := pac.clock(args)
+	if len(args) == 0 || len(args) > 2 {
+		return false, nil
+	}
+	from := pacIndex(pacWeekdays, pacscript.String(args[0]))
+	to := from
+	if len(args) == 2 {
+		to = pacIndex(pacWeekdays, pacscript.String(args[1]))
+	}
+	if from < 0 || to < 0 {
+		return false, nil
+	}
+	return pacInRange(int(now.Weekday()), from, to), nil
+}
+
+// dateRange reports whether today is within a range of days, months and
+// years, e.g. dateRange(1), dateRange("JAN", "MAR"), dateRange(1995) or
+// dateRange(1, "JUN", 1995, 15, "AUG", 1995), with an optional "GMT" last.
+// The range bounds are included.
+func (pac *PAC) dateRange(_ context.Context, args []interface{}) (interface{}, error) {
+	now, args := pac.clock(args)
+	if len(args) == 0 || len(args) > 6 || (len(args) > 1 && len(args)%2 != 0) {
+		return false, nil
+	}
+	first, last := args, args
+	if len(args) > 1 {
+		first, last = args[:len(args)/2], args[len(args)/2:]
+	}
+	start, ok := pacDateBound(now, first, false)
+	if !ok {
+		return false, nil
+	}
+	end, ok := pacDateBound(now, last, true)
+	if !ok {
+		return false, nil
+	}
+
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if !start.After(end) {
+		return !today.Before(start) && !today.After(end), nil
+	}
+	// e.g. dateRange("DEC", "JAN")
+	return !today.Before(start) || !today.After(end), nil
+}
+
+// pacDateBound returns the first, or last, day of a date range bound made
+// of a day, a month name and a year. The fields left out are the current
+// ones, or span the year or month given.
+func pacDateBound(now time.Time, args []interface{}, last bool) (time.Time, bool) {
+	var day, month, year int
+	for _, arg := range args {
+		if s, ok := arg.(string); ok {
+			i := pacIndex(pacMonths, s)
+			if i < 0 || month != 0 {
+				return time.Time{}, false
+			}
+			month = i + 1
+			continue
+		}
+		switch n := int(pacscript.Number(arg)); {
+		case n >= 1 && n <= 31 && day == 0:
+			day = n
+		case n > 31 && year == 0:
+			year = n
+		default:
+			return time.Time{}, false
+		}
+	}
+
+	spansYear, spansMonth := year != 0, year != 0 || month != 0
+	if year == 0 {
+		year = now.Year()
+	}
+	if month == 0 {
+		month = int(now.Month())
+		if spansYear {
+			if month = 1; last {
+				month = 12
+			}
+		}
+	}
+	if day == 0 {
+		day = now.Day()
+		if spansMonth {
+			if day = 1; last {
+				day = time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+			}
+		}
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), true
+}
+
+// timeRange reports whether the time is within a range, e.g. timeRange(12)
+// for noon to 1pm, timeRange(9, 17), timeRange(8, 30, 17, 0) or
+// timeRange(0, 0, 0, 0, 0, 30), with an optional "GMT" last. The range
+// bounds are included.
+func (pac *PAC) timeRange(_ context.Context, args []interface{}) (interface{}, error) {
+	now, args := pac.clock(args)
+	n := make([]int, len(args))
+	for i, arg := range args {
+		n[i] = int(pacscript.Number(arg))
+	}
+	secs := now.Hour()*3600 + now.Minute()*60 + now.Second()
+	switch len(n) {
+	case 1:
+		return now.Hour() == n[0], nil
+	case 2:
+		return pacInRange(now.Hour(), n[0], n[1]), nil
+	case 4:
+		return pacInRange(secs, n[0]*3600+n[1]*60, n[2]*3600+n[3]*60+59), nil
+	case 6:
+		return pacInRange(secs, n[0]*3600+n[1]*60+n[2], n[3]*3600+n[4]*60+n[5]), nil
+	}
+	return false, nil
+}
+
+// clock returns the current time, in UTC when the last argument is "GMT",
+// and the other arguments.
+func (pac *PAC) clock(args []interface{}) (time.Time, []interface{}) {
+	now := pac.now()
+	if len(args) > 0 {
+		if s, ok := args[len(args)-1].(string); ok && strings.EqualFold(s, "GMT") {
+			return now.UTC(), args[:len(args)-1]
+		}
+	}
+	return now, args
+}
+
+// pacInRange reports whether v is between from and to included,
+// wrapping around when from is after to.
+func pacInRange(v, from, to int) bool {
+	if from <= to {
+		return from <= v && v <= to
+	}
+	return v >= from || v <= to
+}
+
+func pacIndex(names []string, name string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func pacArg(args []interface{}, i int) string {
+	if i < len(args) {
+		return pacscript.String(args[i])
+	}
+	return ""
+}
+
+// pacShExpMatch matches str against a shell expression with * and ? wildcards.
+func pacShExpMatch(str, shexp string) bool {
+	pattern := regexp.QuoteMeta(shexp)
+	pattern = strings.Replace(pattern, `\*`, ".*", -1)
+	pattern = strings.Replace(pattern, `\?`, ".", -1)
+	matched, err := regexp.MatchString("^"+pattern+"$", str)
+	return err == nil && matched
+}
+
+// pacMyIPAddress returns the first non-loopback IPv4 address of the host.
+func pacMyIPAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				return ipnet.IP.String()
+			}
+		}
+	}
+	return "127.0.0.1"
+}
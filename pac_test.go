@@ -0,0 +1,229 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPAC = `
+// test proxy auto-config
+var proxy = "PROXY proxy.example.com:3128";
+
+function isInternal(host) {
+	return dnsDomainIs(host, ".corp.example.com") || isInNet(host, "10.0.0.0", "255.0.0.0");
+}
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	if (isPlainHostName(host) || isInternal(host)) {
+		return "DIRECT";
+	}
+	/* socks for the ftp mirror */
+	if (shExpMatch(url, "http://mirror.example.com/*")) {
+		return "SOCKS5 socks.example.com:1080; DIRECT";
+	}
+	if (dnsDomainLevels(host) > 2 && host.substring(0, 4) == "api.") {
+		return "HTTPS secure.example.com:443";
+	}
+	return proxy + "; DIRECT";
+}
+`
+
+func TestPAC_FindProxyForURL(t *testing.T) {
+	asserts := assert.New(t)
+
+	pac, err := ParsePAC(testPAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url    string
+		result string
+		proxy  string
+	}{
+		{"http://intranet/", "DIRECT", ""},
+		{"http://wiki.corp.example.com/", "DIRECT", ""},
+		{"http://10.1.2.3/", "DIRECT", ""},
+		{"http://mirror.example.com/pub/file", "SOCKS5 socks.example.com:1080; DIRECT", "socks5://socks.example.com:1080"},
+		{"http://api.v1.example.com/", "HTTPS secure.example.com:443", "https://secure.example.com:443"},
+		{"https://WWW.Example.com/", "PROXY proxy.example.com:3128; DIRECT", "http://proxy.example.com:3128"},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		result, err := pac.FindProxyForURL(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asserts.Equal(test.result, result, test.url)
+
+		proxyURL, err := pac.Proxy(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.proxy == "" {
+			asserts.Nil(proxyURL, test.url)
+		} else {
+			asserts.Equal(test.proxy, proxyURL.String(), test.url)
+		}
+	}
+}
+
+func TestParsePAC_Error(t *testing.T) {
+	asserts := assert.New(t)
+
+	_, err := ParsePAC(`function other(url, host) { return "DIRECT"; }`)
+	asserts.Error(err)
+
+	_, err = ParsePAC(`function FindProxyForURL(url, host) { return "DIRECT"; `)
+	asserts.Error(err)
+
+	pac, err := ParsePAC(`function FindProxyForURL(url, host) { return unknown(host); }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://example.com/")
+	_, err = pac.Proxy(u)
+	asserts.Error(err)
+}
+
+func TestLoadPAC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.pac")
+	if err := ioutil.WriteFile(path, []byte(testPAC), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pac, err := LoadPAC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://intranet/")
+	proxyURL, err := pac.Proxy(u)
+	assert.NoError(t, err)
+	assert.Nil(t, proxyURL)
+}
+
+func TestPAC_Proxies(t *testing.T) {
+	asserts := assert.New(t)
+
+	pac, err := ParsePAC(`function FindProxyForURL(url, host) {
+		return "PROXY p1:8080; QUIC p2:443; SOCKS p3:1080; DIRECT";
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	pac.now = func() time.Time { return now }
+	u, _ := url.Parse("http://example.com/")
+
+	proxies, err := pac.Proxies(context.Background(), u)
+	if asserts.NoError(err) && asserts.Len(proxies, 3) {
+		asserts.Equal("http://p1:8080", proxies[0].String())
+		asserts.Equal("socks5://p3:1080", proxies[1].String())
+		asserts.Nil(proxies[2])
+	}
+
+	// failed proxies are skipped for a while
+	proxyURL, _ := pac.Proxy(u)
+	pac.ProxyFailed(proxyURL)
+	proxyURL, _ = pac.Proxy(u)
+	asserts.Equal("socks5://p3:1080", proxyURL.String())
+	pac.ProxyFailed(proxyURL)
+	proxyURL, _ = pac.Proxy(u)
+	asserts.Nil(proxyURL)
+	now = now.Add(PACRetryAfter)
+	proxyURL, _ = pac.Proxy(u)
+	asserts.Equal("http://p1:8080", proxyURL.String())
+}
+
+func TestPAC_DateTime(t *testing.T) {
+	asserts := assert.New(t)
+
+	// Wednesday 2021-03-17 14:30:15 UTC
+	now := time.Date(2021, time.March, 17, 14, 30, 15, 0, time.UTC)
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`weekdayRange("WED")`, true},
+		{`weekdayRange("MON", "FRI")`, true},
+		{`weekdayRange("SAT", "MON")`, false},
+		{`weekdayRange("FRI", "WED", "GMT")`, true},
+		{`dateRange(17)`, true},
+		{`dateRange("MAR")`, true},
+		{`dateRange(2021)`, true},
+		{`dateRange(1, 15)`, false},
+		{`dateRange("JAN", "MAR")`, true},
+		{`dateRange("NOV", "FEB")`, false},
+		{`dateRange("DEC", "MAR")`, true},
+		{`dateRange(1, "MAR", 2021, 16, "MAR", 2021)`, false},
+		{`dateRange("FEB", 2020, "MAR", 2021)`, true},
+		{`timeRange(14)`, true},
+		{`timeRange(9, 17)`, true},
+		{`timeRange(22, 6)`, false},
+		{`timeRange(14, 0, 14, 30)`, true},
+		{`timeRange(14, 30, 10, 14, 30, 20, "GMT")`, true},
+		{`timeRange(14, 30, 16, 14, 31, 0)`, false},
+	}
+	for _, test := range tests {
+		pac, err := ParsePAC(`function FindProxyForURL(url, host) {
+			if (` + test.expr + `) return "PROXY match:80";
+			return "DIRECT";
+		}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pac.now = func() time.Time { return now }
+		u, _ := url.Parse("http://example.com/")
+		proxyURL, err := pac.Proxy(u)
+		if asserts.NoError(err, test.expr) {
+			asserts.Equal(test.match, proxyURL != nil, test.expr)
+		}
+	}
+}
+
+type failingResolver struct {
+	calls int32
+}
+
+func (r *failingResolver) LookupIPAddr(context.Context, string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&r.calls, 1)
+	return nil, errors.New("no such host")
+}
+
+func TestPAC_Resolver(t *testing.T) {
+	asserts := assert.New(t)
+
+	pac, err := ParsePAC(`function FindProxyForURL(url, host) {
+		if (isInNet(host, "10.0.0.0", "255.0.0.0") || isResolvable(host)) return "DIRECT";
+		return "PROXY proxy:80";
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &failingResolver{}
+	pac.Resolver = resolver
+	u, _ := url.Parse("http://unknown.example.com/")
+
+	// failures are cached too
+	for i := 0; i < 3; i++ {
+		proxyURL, err := pac.Proxy(u)
+		if asserts.NoError(err) {
+			asserts.Equal("http://proxy:80", proxyURL.String())
+		}
+	}
+	asserts.Equal(int32(1), atomic.LoadInt32(&resolver.calls))
+
+	// evaluation ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pac.FindProxyForURL(ctx, u)
+	asserts.Equal(context.Canceled, err)
+}
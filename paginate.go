@@ -0,0 +1,336 @@
+package quick
+
+import (
+	"bytes"
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrPageStatus is returned by a Pager when a page has an error status code
+var ErrPageStatus = errors.New("unexpected page status code")
+
+// PageStrategy finds the page following a response.
+type PageStrategy interface {
+	// Next returns the request of the page after resp, the response of req.
+	// A nil request ends the pagination.
+	Next(req *Request, resp *Response) (*Request, error)
+}
+
+// PageStrategyFunc is a function PageStrategy
+type PageStrategyFunc func(req *Request, resp *Response) (*Request, error)
+
+// Next calls f(req, resp).
+func (f PageStrategyFunc) Next(req *Request, resp *Response) (*Request, error) {
+	return f(req, resp)
+}
+
+// LinkPagination follows the rel="next" links of the Link header (RFC 8288).
+func LinkPagination() PageStrategy {
+	return PageStrategyFunc(func(req *Request, resp *Response) (*Request, error) {
+		link, ok := parseLinkHeader(resp.Header.Values("Link"))["next"]
+		if !ok {
+			return nil, nil
+		}
+		base := req.URL
+		if resp.HttpRequest != nil {
+			base = resp.HttpRequest.URL
+		}
+		u, err := base.Parse(link)
+		if err != nil {
+			return nil, err
+		}
+		next := req.Copy()
+		next.URL = u
+		return next, nil
+	})
+}
+
+// CursorPagination sets the query parameter param to the cursor found at
+// the JSON path of the body, e.g. "meta.next_cursor". A missing, null or
+// empty cursor ends the pagination.
+func CursorPagination(param, path string) PageStrategy {
+	return PageStrategyFunc(func(req *Request, resp *Response) (*Request, error) {
+		value := jsonPath(resp.Body.Bytes(), path)
+		var cursor string
+		switch value.ValueType() {
+		case jsoniter.StringValue, jsoniter.NumberValue:
+			cursor = value.ToString()
+		default:
+			return nil, nil
+		}
+		if cursor == "" {
+			return nil, nil
+		}
+		return withQueryParam(req, param, cursor), nil
+	})
+}
+
+// OffsetPagination increments the query parameter param by limit, the
+// number of items of a page. The first page is at the offset of the
+// request, 0 when missing.
+func OffsetPagination(param string, limit int) PageStrategy {
+	return PageStrategyFunc(func(req *Request, resp *Response) (*Request, error) {
+		offset, err := queryInt(req.URL, param, 0)
+		if err != nil {
+			return nil, err
+		}
+		return withQueryParam(req, param, strconv.Itoa(offset+limit)), nil
+	})
+}
+
+// PagePagination increments the query parameter param, the page number.
+// The first page is the page of the request, 1 when missing.
+func PagePagination(param string) PageStrategy {
+	return PageStrategyFunc(func(req *Request, resp *Response) (*Request, error) {
+		page, err := queryInt(req.URL, param, 1)
+		if err != nil {
+			return nil, err
+		}
+		return withQueryParam(req, param, strconv.Itoa(page+1)), nil
+	})
+}
+
+// Pager iterates over the pages of a paginated API, see Session.Paginate.
+//
+//	pager := session.Paginate(req, quick.LinkPagination()).SetItemsPath("data")
+//	for pager.NextItem() {
+//		var user User
+//		if err := pager.DecodeItem(&user); err != nil {
+//			...
+//		}
+//	}
+//	if err := pager.Err(); err != nil {
+//		...
+//	}
+type Pager struct {
+	session   *Session
+	strategy  PageStrategy
+	ops       []OptionFunc
+	next      *Request
+	page      *Response
+	items     [][]byte
+	item      int
+	itemsPath string
+	maxPages  int
+	maxItems  int
+	pages     int
+	count     int
+	err       error
+}
+
+// Paginate returns a Pager over the pages of req, finding the following
+// pages with strategy. Options apply to every page request.
+//
+// The pagination ends when the strategy finds no next page, on an empty
+// page, once the max count of pages or items is reached, or on an error.
+func (session *Session) Paginate(req *Request, strategy PageStrategy, ops ...OptionFunc) *Pager {
+	return &Pager{
+		session:  session,
+		strategy: strategy,
+		ops:      ops,
+		next:     req,
+	}
+}
+
+// SetItemsPath set the JSON path of the page items, e.g. "data.items".
+// Pages without items are empty. By default a page is a JSON array of items,
+// any other body being an empty page.
+func (p *Pager) SetItemsPath(path string) *Pager {
+	p.itemsPath = path
+	return p
+}
+
+// SetMaxPages set the max count of pages, 0 means no limit.
+func (p *Pager) SetMaxPages(n int) *Pager {
+	p.maxPages = n
+	return p
+}
+
+// SetMaxItems set the max count of items, 0 means no limit.
+func (p *Pager) SetMaxItems(n int) *Pager {
+	p.maxItems = n
+	return p
+}
+
+// Next fetches the next page, it returns false when the pagination ended.
+func (p *Pager) Next() bool {
+	p.page, p.items, p.item = nil, nil, -1
+	if p.err != nil || p.next == nil {
+		return false
+	}
+	if (p.maxPages > 0 && p.pages >= p.maxPages) || (p.maxItems > 0 && p.count >= p.maxItems) {
+		return false
+	}
+
+	req := p.next
+	p.next = nil
+	sent := req.Copy() // Suck modifies req
+	resp, err := p.session.Suck(req, p.ops...)
+	if err != nil {
+		p.err = err
+		return false
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		p.err = WrapErrf(ErrPageStatus, "page %d: %s", p.pages+1, resp.Status)
+		return false
+	}
+
+	items, empty, err := pageItems(resp.Body.Bytes(), p.itemsPath)
+	if err != nil {
+		p.err = WrapErrf(err, "page %d: decode items", p.pages+1)
+		return false
+	}
+	if empty {
+		return false
+	}
+	if p.maxItems > 0 && len(items) > p.maxItems-p.count {
+		items = items[:p.maxItems-p.count]
+	}
+
+	p.pages++
+	p.count += len(items)
+	p.page, p.items = resp, items
+
+	p.next, err = p.strategy.Next(sent, resp)
+	if err != nil {
+		p.err = WrapErrf(err, "page %d: next page", p.pages)
+		p.next = nil
+	}
+	return true
+}
+
+// Page returns the current page.
+func (p *Pager) Page() *Response {
+	return p.page
+}
+
+// Items returns the raw JSON items of the current page.
+func (p *Pager) Items() [][]byte {
+	return p.items
+}
+
+// NextItem moves to the next item, fetching the next page when needed.
+// It returns false when the pagination ended.
+func (p *Pager) NextItem() bool {
+	for p.page == nil || p.item+1 >= len(p.items) {
+		if !p.Next() {
+			return false
+		}
+	}
+	p.item++
+	return true
+}
+
+// Item returns the raw JSON of the current item.
+func (p *Pager) Item() []byte {
+	if p.page == nil || p.item < 0 || p.item >= len(p.items) {
+		return nil
+	}
+	return p.items[p.item]
+}
+
+// DecodeItem decodes the current item into v.
+func (p *Pager) DecodeItem(v interface{}) error {
+	return json.Unmarshal(p.Item(), v)
+}
+
+// Err returns the error that ended the pagination, if any.
+func (p *Pager) Err() error {
+	return p.err
+}
+
+// pageItems returns the JSON items at path of body, and whether the page is empty.
+// Without path, a body that is not an array is an empty page.
+func pageItems(body []byte, path string) ([][]byte, bool, error) {
+	trimmed := bytes.TrimSpace(body)
+	if path == "" {
+		if len(trimmed) == 0 || trimmed[0] != '[' {
+			return nil, true, nil
+		}
+	} else {
+		value := jsonPath(body, path)
+		if value.ValueType() != jsoniter.ArrayValue {
+			return nil, true, nil
+		}
+		trimmed = []byte(value.ToString())
+	}
+
+	var raw []jsoniter.RawMessage
+	if err := json.Unmarshal(trimmed, &raw); err != nil {
+		return nil, false, err
+	}
+	items := make([][]byte, len(raw))
+	for i, item := range raw {
+		items[i] = item
+	}
+	return items, len(items) == 0, nil
+}
+
+// jsonPath returns the value at the dot separated path of body,
+// array indexes being numbers: "data.items.0.id".
+func jsonPath(body []byte, path string) jsoniter.Any {
+	var keys []interface{}
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			if i, err := strconv.Atoi(key); err == nil {
+				keys = append(keys, i)
+			} else {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return json.Get(body, keys...)
+}
+
+// parseLinkHeader returns the targets of the Link header values by relation type.
+func parseLinkHeader(values []string) map[string]string {
+	links := make(map[string]string)
+	for _, v := range values {
+		for _, link := range strings.Split(v, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = target[1 : len(target)-1]
+			for _, param := range parts[1:] {
+				name, value := param, ""
+				if i := strings.IndexByte(param, '='); i >= 0 {
+					name, value = param[:i], strings.Trim(strings.TrimSpace(param[i+1:]), `"`)
+				}
+				if !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(value) {
+					rel = strings.ToLower(rel)
+					if _, ok := links[rel]; !ok {
+						links[rel] = target
+					}
+				}
+			}
+		}
+	}
+	return links
+}
+
+// withQueryParam returns a copy of req with the query parameter name set to value.
+func withQueryParam(req *Request, name, value string) *Request {
+	next := req.Copy()
+	query := next.URL.Query()
+	query.Set(name, value)
+	next.URL.RawQuery = query.Encode()
+	return next
+}
+
+// queryInt returns the integer query parameter name of u, def when missing.
+func queryInt(u *url.URL, name string, def int) (int, error) {
+	v := u.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
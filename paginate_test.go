@@ -0,0 +1,141 @@
+package quick
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// RunPageServer serves 7 items, 3 per page, in several pagination styles.
+//
+//	/link?page=N       JSON array, Link header to the next page
+//	/cursor?cursor=N   {"data": [...], "meta": {"next": N}}
+//	/offset?offset=N   {"data": [...]}, empty past the last item
+func RunPageServer() *httptest.Server {
+	const total, size = 7, 3
+	items := func(from int) []int {
+		var page []int
+		for i := from; i < from+size && i < total; i++ {
+			page = append(page, i)
+		}
+		return page
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/link":
+			page, _ := strconv.Atoi(query.Get("page"))
+			if (page+1)*size < total {
+				w.Header().Add("Link", fmt.Sprintf(`</link?page=%d>; rel="next", </link?page=0>; rel="first"`, page+1))
+			}
+			_ = json.NewEncoder(w).Encode(items(page * size))
+		case "/cursor":
+			from, _ := strconv.Atoi(query.Get("cursor"))
+			body := map[string]interface{}{"data": items(from), "meta": map[string]interface{}{}}
+			if from+size < total {
+				body["meta"] = map[string]interface{}{"next": strconv.Itoa(from + size)}
+			}
+			_ = json.NewEncoder(w).Encode(body)
+		case "/offset":
+			from, _ := strconv.Atoi(query.Get("offset"))
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": items(from)})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// collectItems returns the items of every page of pager.
+func collectItems(t *testing.T, pager *Pager) []int {
+	var all []int
+	for pager.NextItem() {
+		var item int
+		if err := pager.DecodeItem(&item); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, item)
+	}
+	return all
+}
+
+func TestSession_Paginate(t *testing.T) {
+	asserts := assert.New(t)
+
+	srv := RunPageServer()
+	defer srv.Close()
+
+	session := NewSession()
+	all := []int{0, 1, 2, 3, 4, 5, 6}
+
+	// link
+	pager := session.Paginate(NewRequest().SetUrl(srv.URL+"/link"), LinkPagination())
+	pages := 0
+	for pager.Next() {
+		pages++
+		asserts.NotEmpty(pager.Items())
+	}
+	asserts.NoError(pager.Err())
+	asserts.Equal(3, pages)
+
+	pager = session.Paginate(NewRequest().SetUrl(srv.URL+"/link"), LinkPagination())
+	asserts.Equal(all, collectItems(t, pager))
+
+	// cursor
+	pager = session.Paginate(NewRequest().SetUrl(srv.URL+"/cursor"), CursorPagination("cursor", "meta.next")).
+		SetItemsPath("data")
+	asserts.Equal(all, collectItems(t, pager))
+	asserts.NoError(pager.Err())
+
+	// offset, ending on the empty page
+	pager = session.Paginate(NewRequest().SetUrl(srv.URL+"/offset"), OffsetPagination("offset", 3)).
+		SetItemsPath("data")
+	asserts.Equal(all, collectItems(t, pager))
+
+	// page number, ending on the empty page
+	pager = session.Paginate(NewRequest().SetUrl(srv.URL+"/link?page=0"), PagePagination("page"))
+	asserts.Equal(all, collectItems(t, pager))
+
+	// max counts
+	pager = session.Paginate(NewRequest().SetUrl(srv.URL+"/offset"), OffsetPagination("offset", 3)).
+		SetItemsPath("data").
+		SetMaxItems(4)
+	asserts.Equal([]int{0, 1, 2, 3}, collectItems(t, pager))
+
+	pager = session.Paginate(NewRequest().SetUrl(srv.URL+"/link?page=1"), LinkPagination()).SetMaxPages(1)
+	asserts.Equal([]int{3, 4, 5}, collectItems(t, pager))
+
+	// base url
+	base := NewSession()
+	base.BaseURL = srv.URL
+	pager = base.Paginate(NewRequest().SetUrl("/link"), LinkPagination())
+	asserts.Equal(all, collectItems(t, pager))
+	asserts.NoError(pager.Err())
+	pager = base.Paginate(NewRequest().SetUrl(srv.URL+"/link"), LinkPagination())
+	asserts.Equal(all, collectItems(t, pager))
+
+	// an object without items path is an empty page
+	pager = session.Paginate(NewRequest().SetUrl(srv.URL+"/offset"), PagePagination("page"))
+	asserts.Empty(collectItems(t, pager))
+	asserts.NoError(pager.Err())
+
+	// error status
+	pager = session.Paginate(NewRequest().SetUrl(srv.URL+"/missing"), PagePagination("page"))
+	asserts.False(pager.Next())
+	asserts.ErrorIs(pager.Err(), ErrPageStatus)
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	links := parseLinkHeader([]string{
+		`<https://api.example.com/items?page=2>; rel="next", <https://api.example.com/items?page=9>; rel="last"`,
+		`<https://api.example.com/items?page=1>; rel="first prev"`,
+	})
+	assert.Equal(t, map[string]string{
+		"next":  "https://api.example.com/items?page=2",
+		"last":  "https://api.example.com/items?page=9",
+		"first": "https://api.example.com/items?page=1",
+		"prev":  "https://api.example.com/items?page=1",
+	}, links)
+}
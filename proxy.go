@@ -1,15 +1,24 @@
 package quick
 
 import (
+	"context"
 	"errors"
 	"golang.org/x/net/http/httpproxy"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
-// request context proxy key name
-const ContextProxyKey = "proxy"
+var (
+	// ErrProxyAuth is returned when a proxy refuses the credentials of the proxy url.
+	ErrProxyAuth = errors.New("proxy authentication failed")
+	// ErrProxyRejected is returned when a proxy refuses to connect to the target.
+	ErrProxyRejected = errors.New("proxy rejected the request")
+)
 
 var (
 	// proxyConfigOnce guards proxyConfig
@@ -17,10 +26,34 @@ var (
 	envProxyFuncValue func(*url.URL) (*url.URL, error)
 )
 
+// ProxyError is returned when a request could not go through its proxy.
+type ProxyError struct {
+	// Proxy is the proxy url
+	Proxy *url.URL
+	// StatusCode is the HTTP status code of a refused CONNECT request
+	StatusCode int
+	// Err is the underlying error, see ErrProxyAuth and ErrProxyRejected
+	Err error
+}
+
+func (e *ProxyError) Error() string {
+	proxy := "<nil>"
+	if e.Proxy != nil {
+		proxy = e.Proxy.Redacted()
+	}
+	return "proxy " + proxy + ": " + e.Err.Error()
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
 // proxyFunc get proxy from request context.
 // If there is no proxy set, use default proxy from environment.
 func proxyFunc(req *http.Request) (*url.URL, error) {
-	proxyURL := req.Context().Value(ContextProxyKey) // get proxy *url.URL form context
+	if proxyURL, ok := ProxyFromContext(req.Context()); ok {
+		return proxyURL, nil
+	}
 
 	// If there is no proxy set, use default proxy from environment.
 	// This mitigates expensive lookups on some platforms (e.g. Windows).
@@ -28,13 +61,412 @@ func proxyFunc(req *http.Request) (*url.URL, error) {
 		envProxyFuncValue = httpproxy.FromEnvironment().ProxyFunc()
 	})
 
-	if proxyURL != nil {
-		httpURL, ok := proxyURL.(*url.URL)
+	return envProxyFuncValue(req.URL)
+}
+
+// ProxyConfig is the proxy configuration of a session.
+type ProxyConfig struct {
+	// HTTPProxy is the proxy url of http requests
+	HTTPProxy string
+
+	// HTTPSProxy is the proxy url of https requests
+	HTTPSProxy string
+
+	// NoProxy lists the hosts requests go to directly. An entry is
+	// an IP address, a CIDR block ("10.0.0.0/8"), a domain name matching
+	// itself and its subdomains ("example.com") or its subdomains only
+	// (".example.com" or "*.example.com"), with an optional port,
+	// or "*" for every host.
+	// Requests to localhost and loopback addresses are never proxied.
+	NoProxy []string
+
+	// PACFile is the path of a proxy auto-config script.
+	// When set, the script decides the proxy of every request,
+	// the fields above are ignored. A session evaluates it with the request
+	// context and resolves hosts with the session resolver; when a proxy
+	// fails, the request is sent through the next entry of the result.
+	//
+	// The script is interpreted, not run by a JavaScript engine: it may use
+	// functions, var, if/else, return, the usual operators including ?:,
+	// the PAC functions and the string methods toLowerCase, toUpperCase,
+	// indexOf, substring and length. A script using other syntax, e.g.
+	// loops, arrays or regular expressions, fails to load with an error
+	// naming its line. A call is evaluated in at most a second.
+	PACFile string
+}
+
+// ProxyConfigFromEnvironment returns the proxy configuration of the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+// (or the lowercase versions thereof).
+func ProxyConfigFromEnvironment() *ProxyConfig {
+	env := httpproxy.FromEnvironment()
+	cfg := &ProxyConfig{
+		HTTPProxy:  env.HTTPProxy,
+		HTTPSProxy: env.HTTPSProxy,
+	}
+	if os.Getenv("REQUEST_METHOD") != "" {
+		// like net/http, ignore HTTP_PROXY in a CGI environment
+		cfg.HTTPProxy = ""
+	}
+	for _, host := range strings.Split(env.NoProxy, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			cfg.NoProxy = append(cfg.NoProxy, host)
+		}
+	}
+	return cfg
+}
+
+// ProxyFunc returns a function that gives the proxy of a request url,
+// nil for direct requests.
+func (cfg *ProxyConfig) ProxyFunc() (func(*url.URL) (*url.URL, error), error) {
+	if cfg.PACFile != "" {
+		pac, err := LoadPAC(cfg.PACFile)
+		if err != nil {
+			return nil, err
+		}
+		return pac.Proxy, nil
+	}
+
+	return (&httpproxy.Config{
+		HTTPProxy:  cfg.HTTPProxy,
+		HTTPSProxy: cfg.HTTPSProxy,
+		NoProxy:    strings.Join(cfg.NoProxy, ","),
+	}).ProxyFunc(), nil
+}
+
+// proxyFunc returns the proxy func of cfg, and its PAC script if any.
+// The script resolves hosts with resolver.
+func (cfg *ProxyConfig) proxyFunc(resolver Resolver) (func(context.Context, *url.URL) (*url.URL, error), *PAC, error) {
+	if cfg.PACFile != "" {
+		pac, err := LoadPAC(cfg.PACFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pac.Resolver = resolver
+		return pac.proxy, pac, nil
+	}
+
+	fn, err := cfg.ProxyFunc()
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(_ context.Context, u *url.URL) (*url.URL, error) {
+		return fn(u)
+	}, nil, nil
+}
+
+type resolvedProxy struct {
+	url *url.URL
+}
+
+// transportProxy is the Proxy func of session transports.
+// It returns the proxy resolved by the session for the request,
+// or resolves it with proxyFunc when the session was bypassed.
+func transportProxy(req *http.Request) (*url.URL, error) {
+	if p, ok := req.Context().Value(resolvedProxyKey).(*resolvedProxy); ok {
+		return p.url, nil
+	}
+	return proxyFunc(req)
+}
+
+// proxyConnectResponse turns a refused CONNECT request into a ProxyError.
+func proxyConnectResponse(_ context.Context, proxyURL *url.URL, _ *http.Request, resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return &ProxyError{Proxy: proxyURL, StatusCode: resp.StatusCode, Err: ErrProxyAuth}
+	default:
+		return &ProxyError{Proxy: proxyURL, StatusCode: resp.StatusCode, Err: ErrProxyRejected}
+	}
+}
+
+// proxyErr wraps errors connecting to proxyURL into a ProxyError.
+func proxyErr(proxyURL *url.URL, err error) error {
+	var pe *ProxyError
+	if errors.As(err, &pe) {
+		return err
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "proxyconnect" {
+		return &ProxyError{Proxy: proxyURL, Err: err}
+	}
+	return err
+}
+
+// sessionTransport is the http.RoundTripper of the session http.Client.
+//
+// It answers requests from the session cache when set, resolves the request
+// proxy once with the session proxy handler, sends requests through SOCKS
+// proxies with the session dialer, and hands every other request to the
+// session RoundTripper.
+type sessionTransport struct {
+	session *Session
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recordRedirect(req)
+	if c := t.session.cache; c != nil {
+		return t.session.cacheRoundTrip(c, req)
+	}
+	return t.session.roundTrip(req)
+}
+
+// roundTrip sends req over the network, applying the session circuit
+// breaker, rate limit and bulkhead, in that order: a request failing fast
+// on an open circuit does not take a rate limit token or a bulkhead slot.
+func (session *Session) roundTrip(req *http.Request) (resp *http.Response, err error) {
+	sent := false
+	if cb := session.circuitBreaker; cb != nil {
+		host := req.URL.Host
+		if err := cb.allow(host); err != nil {
+			return nil, err
+		}
+		defer func() {
+			// waiting for the rate limit or the bulkhead, or failing to
+			// resolve the proxy is not a host failure
+			if !sent {
+				cb.cancel(host)
+				return
+			}
+			cb.report(host, resp, err)
+		}()
+	}
+
+	if err := session.rateLimiter.wait(req.Context(), req.URL); err != nil {
+		return nil, err
+	}
+
+	if bh := session.bulkhead; bh != nil {
+		release, err := bh.acquire(req.Context(), req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			// an upgraded connection is no longer a request in flight
+			if err != nil || resp == nil || resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+				release()
+				return
+			}
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+		}()
+	}
+
+	proxyURL, pool, err := session.resolveProxy(req)
+	if err != nil {
+		return nil, &ProxyError{Proxy: proxyURL, Err: err}
+	}
+	sent = true
+
+	tried := map[string]bool{}
+	for {
+		resp, err = session.sendProxy(req, proxyURL, pool)
+		if err == nil || proxyURL == nil || pool != nil {
+			break
+		}
+		tried[proxyURL.String()] = true
+		retry, next, ok := session.pacFallback(req, proxyURL, err)
+		if !ok || (next != nil && tried[next.String()]) {
+			break
+		}
+		req, proxyURL = retry, next
+	}
+	if err != nil && proxyURL != nil {
+		err = proxyErr(proxyURL, err)
+	}
+	if err == nil {
+		session.rateLimiter.observe(req.URL, resp)
+	}
+	return resp, err
+}
+
+// sendProxy sends req through proxyURL, nil for a direct request,
+// reporting the outcome to pool when the proxy comes from it and req
+// was not cancelled.
+func (session *Session) sendProxy(req *http.Request, proxyURL *url.URL, pool *ProxyPool) (*http.Response, error) {
+	ctx := context.WithValue(req.Context(), resolvedProxyKey, &resolvedProxy{url: proxyURL})
+	req = req.WithContext(ctx)
+	rt := session.roundTripper
+	if proxyURL != nil && isSocksScheme(proxyURL.Scheme) {
+		if t := session.socksTransport(proxyURL); t != nil {
+			rt = rebaseTransport(rt, session.transport, t)
+		}
+	} else if websocketUpgrade(req) {
+		if t := session.http1Transport(); t != nil {
+			rt = rebaseTransport(rt, session.transport, t)
+		}
+	}
+
+	startTime := time.Now()
+	resp, err := rt.RoundTrip(req)
+	// a cancelled request, e.g. a losing hedged attempt, says nothing of the proxy
+	if pool != nil && req.Context().Err() == nil {
+		pool.Report(proxyURL, time.Since(startTime), err)
+	}
+	return resp, err
+}
+
+// pacFallback returns req to send through the next proxy of the session PAC
+// script, after the proxy it chose failed with err. ok is false when the
+// proxy was not chosen by the script, or req can not be sent again.
+func (session *Session) pacFallback(req *http.Request, failed *url.URL, err error) (retry *http.Request, next *url.URL, ok bool) {
+	if !proxyFailure(err) || req.Context().Err() != nil {
+		return nil, nil, false
+	}
+	if _, ok := ProxyFromContext(req.Context()); ok {
+		return nil, nil, false
+	}
+	session.proxyMu.Lock()
+	pac := session.proxyPAC
+	custom := session.customProxyHandler
+	session.proxyMu.Unlock()
+	if pac == nil || custom {
+		return nil, nil, false
+	}
+
+	pac.ProxyFailed(failed)
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, nil, false
+	}
+	next, err = pac.proxy(req.Context(), req.URL)
+	if err != nil || (next != nil && next.String() == failed.String()) {
+		return nil, nil, false
+	}
+	retry = req
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, nil, false
+		}
+		retry = req.Clone(req.Context())
+		retry.Body = body
+	}
+	return retry, next, true
+}
+
+// resolveProxy returns the proxy of req: the request or session proxy,
+// else a proxy of the session ProxyPool, else the proxy handler one.
+// The pool is returned when the proxy comes from it.
+func (session *Session) resolveProxy(req *http.Request) (*url.URL, *ProxyPool, error) {
+	pool := session.proxyPool
+	if pool != nil {
+		if _, ok := ProxyFromContext(req.Context()); !ok {
+			u, err := pool.Proxy(req)
+			return u, pool, err
+		}
+	}
+	u, err := session.proxyHandler(req)
+	return u, nil, err
+}
+
+// defaultProxyHandler is the session proxy handler by default.
+// It returns the request proxy, else the proxy of the session ProxyConfig.
+func (session *Session) defaultProxyHandler(req *http.Request) (*url.URL, error) {
+	if proxyURL, ok := ProxyFromContext(req.Context()); ok {
+		return proxyURL, nil
+	}
+
+	session.proxyMu.Lock()
+	fn := session.proxyConfigFunc
+	session.proxyMu.Unlock()
+	return fn(req.Context(), req.URL)
+}
+
+// transportWrapper is a RoundTripper wrapping another one, e.g. auth.Transport.
+// The session sends requests through its wrapper of the session
+// *http.Transport with a SOCKS or HTTP/1.1 clone of the transport as base.
+type transportWrapper interface {
+	http.RoundTripper
+	Unwrap() http.RoundTripper
+	WithBase(base http.RoundTripper) http.RoundTripper
+}
+
+// wrapsTransport reports whether rt is transport, or wraps it.
+func wrapsTransport(rt http.RoundTripper, transport *http.Transport) bool {
+	for rt != nil {
+		if t, ok := rt.(*http.Transport); ok {
+			return t == transport
+		}
+		w, ok := rt.(transportWrapper)
 		if !ok {
-			return nil, WrapErr(errors.New("proxy address is not of type *url.URL"), "HTTP Proxy error, please check proxy url")
+			return false
 		}
-		return httpURL, nil
+		rt = w.Unwrap()
 	}
+	return false
+}
 
-	return envProxyFuncValue(req.URL)
+// rebaseTransport returns rt sending requests with base in place of transport.
+func rebaseTransport(rt http.RoundTripper, transport *http.Transport, base http.RoundTripper) http.RoundTripper {
+	if t, ok := rt.(*http.Transport); ok && t == transport {
+		return base
+	}
+	if w, ok := rt.(transportWrapper); ok && wrapsTransport(rt, transport) {
+		return w.WithBase(rebaseTransport(w.Unwrap(), transport, base))
+	}
+	return rt
+}
+
+// socksTransport returns the transport sending requests through a SOCKS proxy.
+//
+// It is a clone of the session *http.Transport dialing through the proxy,
+// so connections are pooled per proxy. nil is returned when the session
+// RoundTripper neither is nor wraps its *http.Transport; the request is then
+// left to it.
+func (session *Session) socksTransport(proxyURL *url.URL) http.RoundTripper {
+	session.proxyMu.Lock()
+	defer session.proxyMu.Unlock()
+
+	base := session.transport
+	if base == nil || !wrapsTransport(session.roundTripper, base) {
+		return nil
+	}
+
+	key := proxyURL.String()
+	if t, ok := session.socksTransports[key]; ok {
+		return t
+	}
+
+	t := base.Clone()
+	t.Proxy = nil
+	t.DialContext = (&socksDialer{proxy: proxyURL, dialer: session.dialer}).DialContext
+	if _, ok := t.TLSNextProto["h2"]; ok {
+		// connections negotiated by x/net/http2 would be pooled by the base transport
+		t.TLSNextProto = nil
+		t.ForceAttemptHTTP2 = true
+	}
+	var rt socksRoundTripper = t
+	if session.h2c != nil {
+		h2c := newH2CTransport(t.DialContext)
+		h2c.ReadIdleTimeout = session.h2c.ReadIdleTimeout
+		h2c.PingTimeout = session.h2c.PingTimeout
+		h2c.MaxReadFrameSize = session.h2c.MaxReadFrameSize
+		rt = &socksH2C{Transport: t, h2c: h2c}
+	}
+	if session.socksTransports == nil {
+		session.socksTransports = make(map[string]socksRoundTripper)
+	}
+	session.socksTransports[key] = rt
+	return rt
+}
+
+// socksRoundTripper is a transport of a SOCKS proxy
+type socksRoundTripper interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// resetSocksTransports drops the SOCKS and HTTP/1.1 transports after the
+// session transport changed.
+func (session *Session) resetSocksTransports() {
+	session.proxyMu.Lock()
+	defer session.proxyMu.Unlock()
+	for _, t := range session.socksTransports {
+		t.CloseIdleConnections()
+	}
+	session.socksTransports = nil
+	if session.http1 != nil {
+		session.http1.CloseIdleConnections()
+		session.http1 = nil
+	}
 }
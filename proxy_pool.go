@@ -0,0 +1,432 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrNoHealthyProxy is returned when every proxy of a ProxyPool is unhealthy.
+var ErrNoHealthyProxy = errors.New("no healthy proxy in the pool")
+
+// ProxyStrategy selects the proxy of a ProxyPool used by a request.
+type ProxyStrategy int
+
+const (
+	// ProxyRoundRobin uses the healthy proxies in turn.
+	ProxyRoundRobin ProxyStrategy = iota
+	// ProxyRandom uses a random healthy proxy.
+	ProxyRandom
+	// ProxyWeighted uses a random healthy proxy, proportionally to its weight.
+	ProxyWeighted
+	// ProxySticky uses the same proxy for every request to a host,
+	// until that proxy becomes unhealthy.
+	ProxySticky
+)
+
+// ProxyStats reports the usage of a proxy of a ProxyPool.
+type ProxyStats struct {
+	URL        *url.URL
+	Weight     int
+	Healthy    bool
+	Successes  int64
+	Failures   int64
+	AvgLatency time.Duration // average latency of successful requests
+	LastError  error
+}
+
+// ProxyPool is a set of proxies a session rotates through.
+//
+// A proxy is marked unhealthy after MaxFailures consecutive failures to
+// reach it. Once RetryAfter has elapsed, it is given a single request again,
+// or it can be re-probed with Probe / StartHealthCheck.
+//
+//	pool, err := quick.NewProxyPool(quick.ProxyRoundRobin, "http://10.0.0.1:8080", "socks5://10.0.0.2:1080")
+//	session := quick.NewSession().SetProxyPool(pool)
+type ProxyPool struct {
+	// MaxFailures is the number of consecutive failures marking a proxy unhealthy. Default 3.
+	MaxFailures int
+
+	// RetryAfter is how long an unhealthy proxy is left aside. Default 30s.
+	RetryAfter time.Duration
+
+	// ProbeURL, if set, is fetched through unhealthy proxies by Probe.
+	// Otherwise Probe only connects to the proxy.
+	ProbeURL string
+
+	// ProbeTimeout is the timeout of a single probe. Default 10s.
+	ProbeTimeout time.Duration
+
+	// StickyTTL is how long a host keeps its proxy without requests,
+	// with ProxySticky. Default 10m.
+	StickyTTL time.Duration
+
+	strategy ProxyStrategy
+	mu       sync.Mutex
+	proxies  []*pooledProxy
+	next     int
+	sticky   map[string]*stickyProxy
+	rand     *rand.Rand
+	now      func() time.Time
+
+	stickySweep time.Time // next removal of the idle sticky hosts
+}
+
+// stickyProxy is the proxy of a host with ProxySticky
+type stickyProxy struct {
+	proxy *pooledProxy
+	used  time.Time
+}
+
+type pooledProxy struct {
+	url                 *url.URL
+	weight              int
+	healthy             bool
+	consecutiveFailures int
+	retryAt             time.Time
+	successes           int64
+	failures            int64
+	latency             time.Duration
+	lastErr             error
+}
+
+// NewProxyPool create a ProxyPool with the given strategy and proxy urls, each of weight 1.
+func NewProxyPool(strategy ProxyStrategy, rawurls ...string) (*ProxyPool, error) {
+	p := &ProxyPool{
+		MaxFailures:  3,
+		RetryAfter:   30 * time.Second,
+		ProbeTimeout: 10 * time.Second,
+		StickyTTL:    10 * time.Minute,
+		strategy:     strategy,
+		sticky:       make(map[string]*stickyProxy),
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:          time.Now,
+	}
+	for _, rawurl := range rawurls {
+		if err := p.Add(rawurl, 1); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Add add a proxy url to the pool. weight is used by ProxyWeighted, values below 1 count as 1.
+func (p *ProxyPool) Add(rawurl string, weight int) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if weight < 1 {
+		weight = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, proxy := range p.proxies {
+		if proxy.url.String() == u.String() {
+			proxy.weight = weight
+			return nil
+		}
+	}
+	p.proxies = append(p.proxies, &pooledProxy{url: u, weight: weight, healthy: true})
+	return nil
+}
+
+// Remove remove a proxy url from the pool.
+func (p *ProxyPool) Remove(rawurl string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, proxy := range p.proxies {
+		if proxy.url.String() != rawurl {
+			continue
+		}
+		p.proxies = append(p.proxies[:i], p.proxies[i+1:]...)
+		for host, sticky := range p.sticky {
+			if sticky.proxy == proxy {
+				delete(p.sticky, host)
+			}
+		}
+		return
+	}
+}
+
+// Len returns the number of proxies in the pool.
+func (p *ProxyPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.proxies)
+}
+
+// Proxy returns the proxy to use for req. It can be used as a session proxy handler,
+// but only Session.SetProxyPool reports request outcomes to the pool.
+func (p *ProxyPool) Proxy(req *http.Request) (*url.URL, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	candidates := make([]*pooledProxy, 0, len(p.proxies))
+	for _, proxy := range p.proxies {
+		// the unhealthy proxies whose RetryAfter has elapsed get a trial request
+		if proxy.healthy || !now.Before(proxy.retryAt) {
+			candidates = append(candidates, proxy)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoHealthyProxy
+	}
+
+	var proxy *pooledProxy
+	switch p.strategy {
+	case ProxyRandom:
+		proxy = candidates[p.rand.Intn(len(candidates))]
+	case ProxyWeighted:
+		total := 0
+		for _, c := range candidates {
+			total += c.weight
+		}
+		n := p.rand.Intn(total)
+		for _, c := range candidates {
+			if n < c.weight {
+				proxy = c
+				break
+			}
+			n -= c.weight
+		}
+	case ProxySticky:
+		p.sweepSticky(now)
+		host := req.URL.Host
+		if sticky, ok := p.sticky[host]; ok && sticky.proxy.healthy && containsProxy(candidates, sticky.proxy) {
+			proxy = sticky.proxy
+			sticky.used = now
+		} else {
+			proxy = candidates[p.next%len(candidates)]
+			p.next++
+			p.sticky[host] = &stickyProxy{proxy: proxy, used: now}
+		}
+	default:
+		proxy = candidates[p.next%len(candidates)]
+		p.next++
+	}
+
+	if !proxy.healthy {
+		// one trial per RetryAfter
+		proxy.retryAt = now.Add(p.retryAfter())
+	}
+	return proxy.url, nil
+}
+
+// Report records the outcome of a request sent through proxyURL.
+//
+// Only failures to reach the proxy count against it: dial and proxyconnect
+// errors, and *ProxyError. Canceled requests and errors of the target server
+// are ignored.
+func (p *ProxyPool) Report(proxyURL *url.URL, latency time.Duration, err error) {
+	if err != nil && !proxyFailure(err) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proxy := p.lookup(proxyURL)
+	if proxy == nil {
+		return
+	}
+	if err == nil {
+		proxy.successes++
+		proxy.latency += latency
+		proxy.consecutiveFailures = 0
+		proxy.healthy = true
+		return
+	}
+
+	proxy.failures++
+	proxy.lastErr = err
+	proxy.consecutiveFailures++
+	if proxy.consecutiveFailures >= p.maxFailures() && proxy.healthy {
+		proxy.healthy = false
+		proxy.retryAt = p.now().Add(p.retryAfter())
+	}
+}
+
+// Probe checks every unhealthy proxy, marking those that answer healthy again.
+func (p *ProxyPool) Probe(ctx context.Context) {
+	p.mu.Lock()
+	unhealthy := make([]*url.URL, 0)
+	for _, proxy := range p.proxies {
+		if !proxy.healthy {
+			unhealthy = append(unhealthy, proxy.url)
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, u := range unhealthy {
+		wg.Add(1)
+		go func(u *url.URL) {
+			defer wg.Done()
+			if err := p.probe(ctx, u); err != nil {
+				return
+			}
+			p.mu.Lock()
+			if proxy := p.lookup(u); proxy != nil {
+				proxy.healthy = true
+				proxy.consecutiveFailures = 0
+			}
+			p.mu.Unlock()
+		}(u)
+	}
+	wg.Wait()
+}
+
+// StartHealthCheck probes the unhealthy proxies every interval until stop is called.
+func (p *ProxyPool) StartHealthCheck(interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.Probe(ctx)
+			}
+		}
+	}()
+	return cancel
+}
+
+// Stats returns the usage of every proxy of the pool.
+func (p *ProxyPool) Stats() []ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]ProxyStats, 0, len(p.proxies))
+	for _, proxy := range p.proxies {
+		s := ProxyStats{
+			URL:       proxy.url,
+			Weight:    proxy.weight,
+			Healthy:   proxy.healthy,
+			Successes: proxy.successes,
+			Failures:  proxy.failures,
+			LastError: proxy.lastErr,
+		}
+		if proxy.successes > 0 {
+			s.AvgLatency = proxy.latency / time.Duration(proxy.successes)
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// probe connects to the proxy, or fetches ProbeURL through it.
+func (p *ProxyPool) probe(ctx context.Context, u *url.URL) error {
+	timeout := p.ProbeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if p.ProbeURL == "" {
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), schemePort(u.Scheme))
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req := NewRequestWithContext(ctx).SetMethod(http.MethodGet).SetUrl(p.ProbeURL)
+	opts := DefaultSessionOptions()
+	opts.DisableDialKeepAlives = true
+	opts.DisableCookieJar = true
+	_, err := NewSession(opts).Suck(req, OptionProxy(u), OptionTimeout(timeout))
+	return err
+}
+
+// sweepSticky removes the hosts unused for StickyTTL, p.mu must be held.
+func (p *ProxyPool) sweepSticky(now time.Time) {
+	if now.Before(p.stickySweep) {
+		return
+	}
+	ttl := p.StickyTTL
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	for host, sticky := range p.sticky {
+		if now.Sub(sticky.used) >= ttl {
+			delete(p.sticky, host)
+		}
+	}
+	p.stickySweep = now.Add(ttl)
+}
+
+// proxyFailure reports whether err is a failure to reach the proxy.
+func proxyFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pe *ProxyError
+	if errors.As(err, &pe) {
+		return true
+	}
+	var oe *net.OpError
+	return errors.As(err, &oe) && (oe.Op == "dial" || oe.Op == "proxyconnect")
+}
+
+func (p *ProxyPool) lookup(u *url.URL) *pooledProxy {
+	if u == nil {
+		return nil
+	}
+	for _, proxy := range p.proxies {
+		if proxy.url == u || proxy.url.String() == u.String() {
+			return proxy
+		}
+	}
+	return nil
+}
+
+func (p *ProxyPool) maxFailures() int {
+	if p.MaxFailures < 1 {
+		return 3
+	}
+	return p.MaxFailures
+}
+
+func (p *ProxyPool) retryAfter() time.Duration {
+	if p.RetryAfter <= 0 {
+		return 30 * time.Second
+	}
+	return p.RetryAfter
+}
+
+func containsProxy(proxies []*pooledProxy, proxy *pooledProxy) bool {
+	for _, p := range proxies {
+		if p == proxy {
+			return true
+		}
+	}
+	return false
+}
+
+// schemePort returns the default port of a proxy url scheme.
+func schemePort(scheme string) string {
+	switch scheme {
+	case "https":
+		return "443"
+	case ProxySchemeSOCKS4, ProxySchemeSOCKS4A, ProxySchemeSOCKS5, ProxySchemeSOCKS5H:
+		return "1080"
+	}
+	return "80"
+}
@@ -0,0 +1,187 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// RunForwardProxy is an HTTP proxy stand-in answering every request with its name.
+func RunForwardProxy(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(name + " " + r.URL.Host))
+	}))
+}
+
+func newPoolRequest(rawurl string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, rawurl, nil)
+	return req
+}
+
+func TestProxyPool_Strategies(t *testing.T) {
+	asserts := assert.New(t)
+
+	pool, err := NewProxyPool(ProxyRoundRobin, "http://p1:8080", "http://p2:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newPoolRequest("http://example.com")
+	for _, want := range []string{"p1", "p2", "p1"} {
+		u, err := pool.Proxy(req)
+		asserts.Nil(err)
+		asserts.Equal(u.Hostname(), want)
+	}
+
+	pool, _ = NewProxyPool(ProxySticky, "http://p1:8080", "http://p2:8080")
+	first, _ := pool.Proxy(newPoolRequest("http://a.example.com"))
+	second, _ := pool.Proxy(newPoolRequest("http://b.example.com"))
+	asserts.NotEqual(first, second)
+	for i := 0; i < 5; i++ {
+		u, _ := pool.Proxy(newPoolRequest("http://a.example.com/" + time.Now().String()))
+		asserts.Equal(u, first)
+	}
+
+	pool, _ = NewProxyPool(ProxyWeighted)
+	_ = pool.Add("http://p1:8080", 1)
+	_ = pool.Add("http://p2:8080", 99)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		u, _ := pool.Proxy(req)
+		counts[u.Hostname()]++
+	}
+	asserts.True(counts["p2"] > counts["p1"])
+}
+
+func TestProxyPool_Health(t *testing.T) {
+	asserts := assert.New(t)
+
+	pool, _ := NewProxyPool(ProxyRoundRobin, "http://p1:8080", "http://p2:8080")
+	pool.MaxFailures = 2
+	pool.RetryAfter = time.Minute
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	p1, _ := url.Parse("http://p1:8080")
+	failure := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	pool.Report(p1, 0, failure)
+	// canceled requests and errors of the target server do not count
+	pool.Report(p1, 0, context.Canceled)
+	pool.Report(p1, 0, &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")})
+	pool.Report(p1, 0, failure)
+
+	req := newPoolRequest("http://example.com")
+	for i := 0; i < 3; i++ {
+		u, _ := pool.Proxy(req)
+		asserts.Equal(u.Hostname(), "p2")
+	}
+
+	stats := pool.Stats()
+	asserts.False(stats[0].Healthy)
+	asserts.Equal(stats[0].Failures, int64(2))
+	asserts.Equal(stats[0].LastError, failure)
+
+	// a trial request once RetryAfter has elapsed, even with a healthy proxy left
+	now = now.Add(time.Minute)
+	trials := 0
+	for i := 0; i < 4; i++ {
+		if u, _ := pool.Proxy(req); u.Hostname() == "p1" {
+			trials++
+		}
+	}
+	asserts.Equal(trials, 1)
+	pool.Report(p1, 0, failure)
+
+	// every proxy unhealthy
+	p2, _ := url.Parse("http://p2:8080")
+	pool.Report(p2, 0, failure)
+	pool.Report(p2, 0, failure)
+	_, err := pool.Proxy(req)
+	asserts.Equal(err, ErrNoHealthyProxy)
+
+	// a single trial request once RetryAfter has elapsed
+	now = now.Add(time.Minute)
+	u, err := pool.Proxy(req)
+	asserts.Nil(err)
+	pool.Report(u, 10*time.Millisecond, nil)
+	asserts.True(pool.Stats()[0].Healthy || pool.Stats()[1].Healthy)
+}
+
+func TestProxyPool_StickyTTL(t *testing.T) {
+	asserts := assert.New(t)
+
+	pool, _ := NewProxyPool(ProxySticky, "http://p1:8080", "http://p2:8080")
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		_, _ = pool.Proxy(newPoolRequest(fmt.Sprintf("http://host%d.example.com", i)))
+	}
+	asserts.Len(pool.sticky, 100)
+
+	// idle hosts are removed
+	now = now.Add(pool.StickyTTL)
+	_, _ = pool.Proxy(newPoolRequest("http://host0.example.com"))
+	asserts.Len(pool.sticky, 1)
+}
+
+func TestSession_SetProxyPool(t *testing.T) {
+	asserts := assert.New(t)
+
+	p1 := RunForwardProxy("p1")
+	defer p1.Close()
+	p2 := RunForwardProxy("p2")
+	p2.Close() // dead proxy
+
+	pool, _ := NewProxyPool(ProxyRoundRobin, p1.URL, p2.URL)
+	pool.MaxFailures = 1
+	session := NewSession().SetProxyPool(pool)
+
+	resp, err := session.Get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "p1 example.com")
+
+	_, err = session.Get("http://example.com/")
+	var proxyErr *ProxyError
+	asserts.True(errors.As(err, &proxyErr))
+
+	// the dead proxy is now skipped
+	for i := 0; i < 3; i++ {
+		resp, err = session.Get("http://example.com/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		asserts.Equal(resp.Body.String(), "p1 example.com")
+	}
+
+	stats := pool.Stats()
+	asserts.True(stats[0].Healthy)
+	asserts.Equal(stats[0].Successes, int64(4))
+	asserts.False(stats[1].Healthy)
+	asserts.Equal(stats[1].Failures, int64(1))
+
+	// a request proxy takes precedence over the pool
+	direct := RunServer()
+	defer direct.Close()
+	resp, err = session.Get(direct.URL, OptionProxy(p1.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Contains(resp.Body.String(), "p1")
+	asserts.Equal(pool.Stats()[0].Successes, int64(4))
+
+	// probing keeps the dead proxy aside and brings a live one back
+	pool.Report(pool.Stats()[0].URL, 0, &ProxyError{Proxy: pool.Stats()[0].URL, Err: errors.New("timeout")})
+	asserts.False(pool.Stats()[0].Healthy)
+	pool.Probe(context.Background())
+	asserts.True(pool.Stats()[0].Healthy)
+	asserts.False(pool.Stats()[1].Healthy)
+}
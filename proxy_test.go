@@ -0,0 +1,438 @@
+package quick
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// socksProxy is an in-process SOCKS4/5 proxy stand-in connecting every
+// request to target and recording the requested address.
+type socksProxy struct {
+	listener net.Listener
+	target   string
+	username string
+	password string
+
+	mu        sync.Mutex
+	requested []string
+}
+
+func RunSocksProxy(t *testing.T, target, username, password string) *socksProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &socksProxy{listener: l, target: target, username: username, password: password}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *socksProxy) URL(scheme string, user *url.Userinfo) string {
+	return (&url.URL{Scheme: scheme, User: user, Host: p.listener.Addr().String()}).String()
+}
+
+func (p *socksProxy) Requested() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.requested...)
+}
+
+func (p *socksProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return
+	}
+	var ok bool
+	if version[0] == 0x05 {
+		ok = p.handshake5(conn)
+	} else {
+		ok = p.handshake4(conn)
+	}
+	if !ok {
+		return
+	}
+
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go func() { _, _ = io.Copy(upstream, conn) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func (p *socksProxy) handshake5(conn net.Conn) bool {
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[0]]); err != nil {
+		return false
+	}
+	if p.username == "" {
+		_, _ = conn.Write([]byte{0x05, 0x00})
+	} else {
+		_, _ = conn.Write([]byte{0x05, 0x02})
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return false
+		}
+		username := make([]byte, buf[1])
+		_, _ = io.ReadFull(conn, username)
+		_, _ = io.ReadFull(conn, buf[:1])
+		password := make([]byte, buf[0])
+		_, _ = io.ReadFull(conn, password)
+		if string(username) != p.username || string(password) != p.password {
+			_, _ = conn.Write([]byte{0x01, 0x01})
+			return false
+		}
+		_, _ = conn.Write([]byte{0x01, 0x00})
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return false
+	}
+	var host string
+	switch buf[3] {
+	case 0x01:
+		_, _ = io.ReadFull(conn, buf[:4])
+		host = "ipv4:" + net.IP(buf[:4]).String()
+	case 0x04:
+		_, _ = io.ReadFull(conn, buf[:16])
+		host = "ipv6:" + net.IP(buf[:16]).String()
+	case 0x03:
+		_, _ = io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		_, _ = io.ReadFull(conn, name)
+		host = "domain:" + string(name)
+	}
+	_, _ = io.ReadFull(conn, buf[:2])
+
+	p.mu.Lock()
+	p.requested = append(p.requested, host)
+	p.mu.Unlock()
+
+	_, err := conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	return err == nil
+}
+
+func (p *socksProxy) handshake4(conn net.Conn) bool {
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return false
+	}
+	readString := func() string {
+		var s []byte
+		b := make([]byte, 1)
+		for {
+			if _, err := io.ReadFull(conn, b); err != nil || b[0] == 0 {
+				return string(s)
+			}
+			s = append(s, b[0])
+		}
+	}
+	userID := readString()
+	host := "ipv4:" + net.IP(buf[3:7]).String()
+	if buf[3] == 0 && buf[4] == 0 && buf[5] == 0 && buf[6] != 0 {
+		host = "domain:" + readString()
+	}
+
+	p.mu.Lock()
+	p.requested = append(p.requested, userID+"@"+host)
+	p.mu.Unlock()
+
+	_, err := conn.Write([]byte{0x00, 0x5a, 0, 0, 0, 0, 0, 0})
+	return err == nil
+}
+
+func TestSession_SOCKSProxy(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+	_, port, _ := net.SplitHostPort(ser.Listener.Addr().String())
+	rawurl := "http://quick.test:" + port
+
+	proxy := RunSocksProxy(t, ser.Listener.Addr().String(), "", "")
+	resolver := &staticResolver{addrs: []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}}
+	session := NewSession().SetResolver(resolver)
+
+	tests := []struct {
+		scheme    string
+		requested string
+	}{
+		{ProxySchemeSOCKS5H, "domain:quick.test"},
+		{ProxySchemeSOCKS5, "ipv4:127.0.0.1"},
+		{ProxySchemeSOCKS4A, "quick@domain:quick.test"},
+		{ProxySchemeSOCKS4, "quick@ipv4:127.0.0.1"},
+	}
+	for i, test := range tests {
+		resp, err := session.Get(rawurl, OptionProxy(proxy.URL(test.scheme, url.User("quick"))))
+		if err != nil {
+			t.Fatal(test.scheme, err)
+		}
+		asserts.Equal(resp.Body.String(), "quick")
+		asserts.Equal(proxy.Requested()[i], test.requested, test.scheme)
+	}
+}
+
+// countingTransport counts the requests it hands to its base.
+type countingTransport struct {
+	base  http.RoundTripper
+	calls *int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(t.calls, 1)
+	return t.base.RoundTrip(req)
+}
+
+func (t *countingTransport) Unwrap() http.RoundTripper {
+	return t.base
+}
+
+func (t *countingTransport) WithBase(base http.RoundTripper) http.RoundTripper {
+	return &countingTransport{base: base, calls: t.calls}
+}
+
+func TestSession_SOCKSProxy_WrappedTransport(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+
+	proxy := RunSocksProxy(t, ser.Listener.Addr().String(), "", "")
+	session := NewSession()
+	var calls int32
+	session.SetTransport(&countingTransport{base: session.GetTransport(), calls: &calls})
+
+	// the wrapper is kept in front of the SOCKS transport
+	resp, err := session.Get(ser.URL, OptionProxy(proxy.URL(ProxySchemeSOCKS4, url.User("quick"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal("quick", resp.Body.String())
+	asserts.Equal([]string{"quick@ipv4:127.0.0.1"}, proxy.Requested())
+	asserts.Equal(int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestSession_SOCKS5Auth(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+
+	proxy := RunSocksProxy(t, ser.Listener.Addr().String(), "quick", "secret")
+	session := NewSession()
+
+	resp, err := session.Get(ser.URL, OptionProxy(proxy.URL("socks5h", url.UserPassword("quick", "secret"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "quick")
+
+	_, err = session.Get(ser.URL, OptionProxy(proxy.URL("socks5h", url.UserPassword("quick", "wrong"))))
+	var proxyErr *ProxyError
+	asserts.True(errors.As(err, &proxyErr))
+	asserts.True(errors.Is(err, ErrProxyAuth))
+	asserts.NotContains(proxyErr.Error(), "wrong")
+}
+
+// RunConnectProxy is an in-process HTTPS proxy stand-in
+// requiring Proxy-Authorization on CONNECT requests.
+func RunConnectProxy(username, password string) *httptest.Server {
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != auth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = upstream.Close()
+			return
+		}
+		go func() {
+			defer upstream.Close()
+			_, _ = io.Copy(upstream, conn)
+		}()
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(conn, upstream)
+		}()
+	}))
+}
+
+func TestSession_ConnectProxyAuth(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("tunnel"))
+	}))
+	defer ser.Close()
+
+	proxy := RunConnectProxy("quick", "secret")
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	session := NewSession().InsecureSkipVerify(true)
+
+	proxyURL.User = url.UserPassword("quick", "secret")
+	resp, err := session.Get(ser.URL, OptionProxy(proxyURL.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "tunnel")
+
+	proxyURL.User = url.UserPassword("quick", "wrong")
+	_, err = session.Get(ser.URL, OptionProxy(proxyURL.String()))
+	var proxyErr *ProxyError
+	asserts.True(errors.As(err, &proxyErr))
+	asserts.True(errors.Is(err, ErrProxyAuth))
+	asserts.Equal(proxyErr.StatusCode, http.StatusProxyAuthRequired)
+}
+
+func TestProxyConfig_NoProxy(t *testing.T) {
+	asserts := assert.New(t)
+
+	cfg := &ProxyConfig{
+		HTTPProxy:  "http://proxy.example.com:3128",
+		HTTPSProxy: "http://secure.example.com:3128",
+		NoProxy:    []string{"10.0.0.0/8", "*.internal.example.com", "example.org", "192.168.1.1:8080"},
+	}
+	proxyFunc, err := cfg.ProxyFunc()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url   string
+		proxy string
+	}{
+		{"http://example.com/", "http://proxy.example.com:3128"},
+		{"https://example.com/", "http://secure.example.com:3128"},
+		{"http://10.20.30.40/", ""},
+		{"http://11.20.30.40/", "http://proxy.example.com:3128"},
+		{"http://api.internal.example.com/", ""},
+		{"http://example.org/", ""},
+		{"http://www.example.org/", ""},
+		{"http://192.168.1.1:8080/", ""},
+		{"http://192.168.1.1:9090/", "http://proxy.example.com:3128"},
+		{"http://localhost/", ""},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		proxyURL, err := proxyFunc(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.proxy == "" {
+			asserts.Nil(proxyURL, test.url)
+		} else {
+			asserts.Equal(test.proxy, proxyURL.String(), test.url)
+		}
+	}
+}
+
+func TestSession_SetProxyConfig(t *testing.T) {
+	asserts := assert.New(t)
+
+	p1 := RunForwardProxy("p1")
+	defer p1.Close()
+
+	session := NewSession().SetProxyConfig(&ProxyConfig{HTTPProxy: p1.URL})
+	resp, err := session.Get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "p1 example.com")
+
+	// PAC script
+	script := `function FindProxyForURL(url, host) {
+		if (host == "example.com") return "PROXY ` + strings.TrimPrefix(p1.URL, "http://") + `";
+		return "DIRECT";
+	}`
+	path := filepath.Join(t.TempDir(), "proxy.pac")
+	if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	session = NewSession(&SessionOptions{ProxyConfig: &ProxyConfig{PACFile: path}})
+	resp, err = session.Get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "p1 example.com")
+
+	direct := RunServer()
+	defer direct.Close()
+	resp, err = session.Get(direct.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.NotContains(resp.Body.String(), "p1")
+
+	// an invalid PAC file keeps the previous configuration
+	session.SetProxyConfig(&ProxyConfig{PACFile: filepath.Join(t.TempDir(), "missing.pac")})
+	asserts.Equal(path, session.GetProxyConfig().PACFile)
+
+	// a failing proxy falls back to the next entry, and is skipped afterwards;
+	// the script resolves hosts with the session resolver
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = closed.Close()
+	script = `function FindProxyForURL(url, host) {
+		if (!isResolvable(host)) return "DIRECT";
+		return "PROXY ` + closed.Addr().String() + `; PROXY ` + strings.TrimPrefix(p1.URL, "http://") + `";
+	}`
+	if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolver := &staticResolver{addrs: []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}}
+	session = NewSession().SetResolver(resolver).SetProxyConfig(&ProxyConfig{PACFile: path})
+	for i := 0; i < 2; i++ {
+		resp, err = session.Post("http://quick.test/", OptionBody("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		asserts.Equal("p1 quick.test", resp.Body.String())
+	}
+	asserts.Equal(int32(1), atomic.LoadInt32(&resolver.calls))
+
+	// not with a custom proxy handler
+	session.SetProxyHandler(func(req *http.Request) (*url.URL, error) {
+		return url.Parse("http://" + closed.Addr().String())
+	})
+	_, err = session.Get("http://quick.test/")
+	asserts.Error(err)
+}
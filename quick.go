@@ -1,6 +1,7 @@
 package quick
 
 import (
+	"context"
 	jsoniter "github.com/json-iterator/go"
 	"net/http"
 	"net/url"
@@ -154,12 +155,58 @@ func SetProxyHandler(handler func(req *http.Request) (*url.URL, error)) *Session
 	return defaultSession.SetProxyHandler(handler)
 }
 
+// SetProxyConfig set global proxy configuration
+func SetProxyConfig(cfg *ProxyConfig) *Session {
+	return defaultSession.SetProxyConfig(cfg)
+}
+
+// SetProxyPool set global proxy pool
+func SetProxyPool(pool *ProxyPool) *Session {
+	return defaultSession.SetProxyPool(pool)
+}
+
+// SetRedirectPolicy set global redirect policy
+func SetRedirectPolicy(policy *RedirectPolicy) *Session {
+	return defaultSession.SetRedirectPolicy(policy)
+}
+
+// SetRateLimit set global rate limit
+func SetRateLimit(rate float64, burst int) *Session {
+	return defaultSession.SetRateLimit(rate, burst)
+}
+
+// SetCircuitBreaker set global circuit breaker
+func SetCircuitBreaker(cb *CircuitBreaker) *Session {
+	return defaultSession.SetCircuitBreaker(cb)
+}
+
+// SetBulkhead set global bulkhead
+func SetBulkhead(b *Bulkhead) *Session {
+	return defaultSession.SetBulkhead(b)
+}
+
+// SetCache set global response cache
+func SetCache(c Cache) *Session {
+	return defaultSession.SetCache(c)
+}
+
 // SetCheckRedirectHandler set global checkRedirect handler
 // handler: func(req *http.Request, via []*http.Request) error
 func SetCheckRedirectHandler(handler func(req *http.Request, via []*http.Request) error) *Session {
 	return defaultSession.SetCheckRedirectHandler(handler)
 }
 
+// SetTransport set global http.RoundTripper
+func SetTransport(rt http.RoundTripper) *Session {
+	return defaultSession.SetTransport(rt)
+}
+
+// SetDialer set global dial func
+// dial: func(ctx context.Context, network, addr string) (net.Conn, error)
+func SetDialer(dial DialFunc) *Session {
+	return defaultSession.SetDialer(dial)
+}
+
 // SetCookieJar set global cookieJar
 func SetCookieJar(jar http.CookieJar) *Session {
 	return defaultSession.SetCookieJar(jar)
@@ -194,6 +241,16 @@ func DisableTrace() *Session {
 	return defaultSession
 }
 
+// EnableDedup coalesces identical global requests in flight, see Session.EnableDedup
+func EnableDedup(headers ...string) *Session {
+	return defaultSession.EnableDedup(headers...)
+}
+
+// DisableDedup disables the coalescing of identical global requests
+func DisableDedup() *Session {
+	return defaultSession.DisableDedup()
+}
+
 // Suck request suck data
 func Suck(req *Request, ops ...OptionFunc) (*Response, error) {
 	return defaultSession.Suck(req, ops...)
@@ -203,3 +260,43 @@ func Suck(req *Request, ops ...OptionFunc) (*Response, error) {
 func Do(req *http.Request) (*Response, error) {
 	return defaultSession.Do(req)
 }
+
+// SuckAll send requests in parallel, see Session.SuckAll
+func SuckAll(ctx context.Context, reqs []*Request, concurrency int, ops ...OptionFunc) []BatchResult {
+	return defaultSession.SuckAll(ctx, reqs, concurrency, ops...)
+}
+
+// SuckAllFailFast send requests in parallel until one fails, see Session.SuckAllFailFast
+func SuckAllFailFast(ctx context.Context, reqs []*Request, concurrency int, ops ...OptionFunc) ([]BatchResult, error) {
+	return defaultSession.SuckAllFailFast(ctx, reqs, concurrency, ops...)
+}
+
+// SuckAllStream send requests in parallel, see Session.SuckAllStream
+func SuckAllStream(ctx context.Context, reqs []*Request, concurrency int, ops ...OptionFunc) <-chan BatchResult {
+	return defaultSession.SuckAllStream(ctx, reqs, concurrency, ops...)
+}
+
+// SuckAsync send request asynchronously, see Session.SuckAsync
+func SuckAsync(req *Request, ops ...OptionFunc) *Future {
+	return defaultSession.SuckAsync(req, ops...)
+}
+
+// Paginate iterate over the pages of req, see Session.Paginate
+func Paginate(req *Request, strategy PageStrategy, ops ...OptionFunc) *Pager {
+	return defaultSession.Paginate(req, strategy, ops...)
+}
+
+// SuckStream send request and stream the response body, see Session.SuckStream
+func SuckStream(req *Request, ops ...OptionFunc) (*Response, error) {
+	return defaultSession.SuckStream(req, ops...)
+}
+
+// SSE connect to a Server-Sent Events stream, see Session.SSE
+func SSE(rawurl string, ops ...OptionFunc) (*EventStream, error) {
+	return defaultSession.SSE(rawurl, ops...)
+}
+
+// WebSocket open a WebSocket connection, see Session.WebSocket
+func WebSocket(rawurl string, ops ...OptionFunc) (*WebSocketConn, error) {
+	return defaultSession.WebSocket(rawurl, ops...)
+}
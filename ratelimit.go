@@ -0,0 +1,228 @@
+package quick
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket refilled with rate tokens per second,
+// holding at most burst tokens.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// DefaultMaxRateLimitPause is the longest a server may pause the requests
+// to its host by default, see Session.SetMaxRateLimitPause.
+const DefaultMaxRateLimitPause = 5 * time.Minute
+
+// rateLimiter limits the requests of a session, globally and per host.
+type rateLimiter struct {
+	mu       sync.Mutex
+	global   *tokenBucket
+	hosts    map[string]*tokenBucket
+	adaptive bool
+	pauses   map[string]time.Time // host => time the server asked to wait until
+	now      func() time.Time
+
+	maxPause time.Duration // cap of the pauses asked by servers
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		hosts:    make(map[string]*tokenBucket),
+		pauses:   make(map[string]time.Time),
+		now:      time.Now,
+		maxPause: DefaultMaxRateLimitPause,
+	}
+}
+
+// setLimit limits all requests to rate per second, with bursts of burst requests.
+// A rate <= 0 removes the limit.
+func (l *rateLimiter) setLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.global = nil
+	if rate > 0 {
+		l.global = newTokenBucket(rate, burst, l.now())
+	}
+}
+
+// setHostLimit limits the requests to a host, or a "host:port" address.
+// A rate <= 0 removes the limit.
+func (l *rateLimiter) setHostLimit(host string, rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rate <= 0 {
+		delete(l.hosts, host)
+		return
+	}
+	l.hosts[host] = newTokenBucket(rate, burst, l.now())
+}
+
+func (l *rateLimiter) setAdaptive(adaptive bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.adaptive = adaptive
+	if !adaptive {
+		l.pauses = make(map[string]time.Time)
+	}
+}
+
+// setMaxPause caps the pauses asked by servers, DefaultMaxRateLimitPause when d <= 0.
+func (l *rateLimiter) setMaxPause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if d <= 0 {
+		d = DefaultMaxRateLimitPause
+	}
+	l.maxPause = d
+}
+
+// reserve takes the tokens of a request to u and returns how long to wait.
+func (l *rateLimiter) reserve(u *url.URL) (time.Duration, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var (
+		wait     time.Duration
+		reserved []*tokenBucket
+	)
+	buckets := []*tokenBucket{l.global, l.hosts[u.Host]}
+	if buckets[1] == nil {
+		buckets[1] = l.hosts[u.Hostname()]
+	}
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		if d := b.reserve(now); d > wait {
+			wait = d
+		}
+		reserved = append(reserved, b)
+	}
+	if until, ok := l.pauses[u.Host]; ok {
+		if d := until.Sub(now); d > wait {
+			wait = d
+		} else if d <= 0 {
+			delete(l.pauses, u.Host)
+		}
+	}
+
+	cancel := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, b := range reserved {
+			if b.tokens++; b.tokens > b.burst {
+				b.tokens = b.burst
+			}
+		}
+	}
+	return wait, cancel
+}
+
+// wait blocks until a request to u is allowed, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, u *url.URL) error {
+	wait, cancel := l.reserve(u)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+// observe pauses the requests to u when resp asks to slow down,
+// with a Retry-After header or exhausted X-RateLimit-Remaining.
+// The pause is capped to maxPause.
+func (l *rateLimiter) observe(u *url.URL, resp *http.Response) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.adaptive {
+		return
+	}
+
+	now := l.now()
+	var until time.Time
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		until = parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+	if until.IsZero() && resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		until = parseRateLimitReset(resp.Header.Get("X-RateLimit-Reset"), now)
+	}
+	if max := now.Add(l.maxPause); until.After(max) {
+		until = max
+	}
+	if until.After(now) && until.After(l.pauses[u.Host]) {
+		l.pauses[u.Host] = until
+	}
+}
+
+// parseRetryAfter parses a Retry-After header: delay seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// parseRateLimitReset parses a X-RateLimit-Reset header:
+// a unix time, or delay seconds for small values.
+func parseRateLimitReset(v string, now time.Time) time.Time {
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	if seconds > 1e9 {
+		return time.Unix(seconds, 0)
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
@@ -0,0 +1,139 @@
+package quick
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	asserts := assert.New(t)
+
+	now := time.Now()
+	b := newTokenBucket(10, 2, now)
+	asserts.Equal(b.reserve(now), time.Duration(0))
+	asserts.Equal(b.reserve(now), time.Duration(0))
+	asserts.Equal(b.reserve(now), 100*time.Millisecond)
+	asserts.Equal(b.reserve(now), 200*time.Millisecond)
+
+	// refilled up to burst
+	now = now.Add(time.Minute)
+	asserts.Equal(b.reserve(now), time.Duration(0))
+	asserts.Equal(b.reserve(now), time.Duration(0))
+	asserts.Equal(b.reserve(now), 100*time.Millisecond)
+}
+
+func TestRateLimiter_Hosts(t *testing.T) {
+	asserts := assert.New(t)
+
+	l := newRateLimiter()
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	l.setHostLimit("a.example.com", 1, 1)
+
+	a, _ := url.Parse("http://a.example.com:8080/")
+	b, _ := url.Parse("http://b.example.com/")
+	wait, _ := l.reserve(a)
+	asserts.Equal(wait, time.Duration(0))
+	wait, cancel := l.reserve(a)
+	asserts.Equal(wait, time.Second)
+	wait, _ = l.reserve(b)
+	asserts.Equal(wait, time.Duration(0))
+
+	// a cancelled reservation gives its token back
+	cancel()
+	now = now.Add(time.Second)
+	wait, _ = l.reserve(a)
+	asserts.Equal(wait, time.Duration(0))
+
+	// adaptive pauses
+	l.setAdaptive(true)
+	l.observe(b, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"2"}}})
+	wait, _ = l.reserve(b)
+	asserts.Equal(wait, 2*time.Second)
+
+	reset := now.Add(time.Minute).Unix()
+	l.observe(a, &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(reset, 10)},
+	}})
+	wait, _ = l.reserve(a)
+	asserts.True(wait > 59*time.Second && wait <= time.Minute)
+
+	// pauses are capped
+	l.observe(b, &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"86400"}}})
+	wait, _ = l.reserve(b)
+	asserts.Equal(DefaultMaxRateLimitPause, wait)
+	l.setMaxPause(10 * time.Second)
+	l.observe(a, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{
+		"Retry-After": {now.AddDate(1, 0, 0).UTC().Format(http.TimeFormat)},
+	}})
+	wait, _ = l.reserve(a)
+	asserts.True(wait > 59*time.Second && wait <= time.Minute)
+	c, _ := url.Parse("http://c.example.com")
+	l.observe(c, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{
+		"Retry-After": {now.AddDate(1, 0, 0).UTC().Format(http.TimeFormat)},
+	}})
+	wait, _ = l.reserve(c)
+	asserts.Equal(10*time.Second, wait)
+}
+
+func TestSession_SetRateLimit(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+
+	session := NewSession().SetRateLimit(50, 1)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := session.Get(ser.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	asserts.True(time.Since(start) >= 70*time.Millisecond)
+
+	// waiting honors the request timeout
+	session.SetRateLimit(0.1, 1)
+	_, _ = session.Get(ser.URL)
+	start = time.Now()
+	_, err := session.Get(ser.URL, OptionTimeout(50*time.Millisecond))
+	asserts.True(errors.Is(err, ErrTimeout))
+	asserts.True(time.Since(start) < time.Second)
+}
+
+func TestSession_SetAdaptiveRateLimit(t *testing.T) {
+	asserts := assert.New(t)
+
+	var calls int32
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("quick"))
+	}))
+	defer ser.Close()
+
+	session := NewSession().SetAdaptiveRateLimit(true)
+	resp, err := session.Get(ser.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.StatusCode, http.StatusTooManyRequests)
+
+	start := time.Now()
+	resp, err = session.Get(ser.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.StatusCode, http.StatusOK)
+	asserts.True(time.Since(start) >= 900*time.Millisecond)
+}
@@ -0,0 +1,226 @@
+package quick
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"reflect"
+	"strconv"
+)
+
+// ErrRecordTooLarge is returned for records larger than the max record size
+var ErrRecordTooLarge = errors.New("record too large")
+
+// DefaultMaxRecordSize is the max size of a record by default
+const DefaultMaxRecordSize = 1 << 20
+
+// recordSeparator starts the records of a JSON text sequence (RFC 7464)
+const recordSeparator = 0x1e
+
+// RecordError is the error of a single record of a stream.
+type RecordError struct {
+	Index  int    // record index, from 0
+	Record []byte // raw record, nil when too large
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return "record " + strconv.Itoa(e.Index) + ": " + e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// StreamDecoder decodes the JSON records of a newline delimited JSON
+// (NDJSON, JSON Lines) or JSON text sequence (application/json-seq) body
+// one by one, see Response.Records.
+//
+//	records := resp.Records()
+//	for records.Next() {
+//		var event Event
+//		if err := records.Decode(&event); err != nil {
+//			// a malformed record, the next ones may be fine
+//			continue
+//		}
+//	}
+//	if err := records.Err(); err != nil {
+//		...
+//	}
+type StreamDecoder struct {
+	r       *bufio.Reader
+	seq     bool
+	maxSize int
+	index   int
+	record  []byte
+	tooBig  bool
+	err     error
+}
+
+// Records returns a decoder of the JSON records of the body. A JSON text
+// sequence is recognized by its application/json-seq content type, any
+// other body is newline delimited.
+//
+// The body is streamed when the response comes from Session.SuckStream,
+// the response should then be closed once decoded.
+func (r *Response) Records() *StreamDecoder {
+	var src io.Reader
+	if r.stream != nil {
+		src = r.stream
+	} else {
+		src = bytes.NewReader(r.Body.Bytes())
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.GetContextType())
+	return &StreamDecoder{
+		r:       bufio.NewReader(src),
+		seq:     mediaType == "application/json-seq",
+		maxSize: DefaultMaxRecordSize,
+		index:   -1,
+	}
+}
+
+// DecodeStream decodes the JSON records of the body one by one, calling fn
+// with each, see StreamDecoder.DecodeStream.
+//
+//	err := resp.DecodeStream(func(event Event) error {
+//		...
+//	})
+func (r *Response) DecodeStream(fn interface{}) error {
+	return r.Records().DecodeStream(fn)
+}
+
+// SetMaxRecordSize set the max size of a record, 0 means no limit.
+// Default is DefaultMaxRecordSize.
+func (d *StreamDecoder) SetMaxRecordSize(n int) *StreamDecoder {
+	d.maxSize = n
+	return d
+}
+
+// Next reads the next record, it returns false at the end of the body
+// or on a read error, see Err.
+func (d *StreamDecoder) Next() bool {
+	if d.err != nil {
+		return false
+	}
+	delim := byte('\n')
+	if d.seq {
+		delim = recordSeparator
+	}
+	for {
+		record, tooBig, err := d.readRecord(delim)
+		if err != nil && err != io.EOF {
+			d.err = err
+			return false
+		}
+		// skip blank lines and empty sequence records
+		if tooBig || len(bytes.TrimSpace(record)) > 0 {
+			d.index++
+			d.record, d.tooBig = bytes.TrimSpace(record), tooBig
+			if tooBig {
+				d.record = nil
+			}
+			return true
+		}
+		if err == io.EOF {
+			d.record, d.tooBig = nil, false
+			return false
+		}
+	}
+}
+
+// readRecord reads until delim or the end of the body. Records larger than
+// the max size are discarded.
+func (d *StreamDecoder) readRecord(delim byte) (record []byte, tooBig bool, err error) {
+	for {
+		chunk, err := d.r.ReadSlice(delim)
+		if err == nil {
+			chunk = chunk[:len(chunk)-1]
+		}
+		if !tooBig {
+			record = append(record, chunk...)
+			if d.maxSize > 0 && len(record) > d.maxSize {
+				record, tooBig = nil, true
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return record, tooBig, err
+	}
+}
+
+// Record returns the raw JSON of the current record, nil when it is too large.
+func (d *StreamDecoder) Record() []byte {
+	return d.record
+}
+
+// Index returns the index of the current record, from 0.
+func (d *StreamDecoder) Index() int {
+	return d.index
+}
+
+// Decode decodes the current record into v. The error is a *RecordError.
+func (d *StreamDecoder) Decode(v interface{}) error {
+	if d.tooBig {
+		return &RecordError{Index: d.index, Err: ErrRecordTooLarge}
+	}
+	if err := json.Unmarshal(d.record, v); err != nil {
+		return &RecordError{Index: d.index, Record: d.record, Err: err}
+	}
+	return nil
+}
+
+// Err returns the read error that ended the decoding, if any.
+func (d *StreamDecoder) Err() error {
+	return d.err
+}
+
+// DecodeStream decodes the records one by one and calls fn with each.
+// Methods cannot have type parameters, so fn is a func(v T) error, T being
+// the type of the records, or a func(v T, err error) error to handle the
+// records failing to decode; fn gets a new T per record.
+//
+// With func(v T) error, a record failing to decode ends the decoding with
+// its *RecordError. With func(v T, err error) error, fn gets that error and
+// a zero v, and a nil error for the records decoded. Decoding goes on while
+// fn returns nil, the error of fn or the read error is returned.
+func (d *StreamDecoder) DecodeStream(fn interface{}) error {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || !decodeStreamFunc(fv.Type()) {
+		return errors.New("DecodeStream: fn must be a func(v T) error or a func(v T, err error) error")
+	}
+	withErr := fv.Type().NumIn() == 2
+	vt := fv.Type().In(0)
+	for d.Next() {
+		v := reflect.New(vt)
+		err := d.Decode(v.Interface())
+		if err != nil {
+			if !withErr {
+				return err
+			}
+			v = reflect.New(vt)
+		}
+		args := []reflect.Value{v.Elem()}
+		if withErr {
+			args = append(args, reflect.ValueOf(&err).Elem())
+		}
+		if out := fv.Call(args)[0]; !out.IsNil() {
+			return out.Interface().(error)
+		}
+	}
+	return d.Err()
+}
+
+// errorType is the type of the error interface.
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// decodeStreamFunc reports whether t is a func(v T) error
+// or a func(v T, err error) error.
+func decodeStreamFunc(t reflect.Type) bool {
+	if t.IsVariadic() || t.NumOut() != 1 || t.Out(0) != errorType {
+		return false
+	}
+	return t.NumIn() == 1 || (t.NumIn() == 2 && t.In(1) == errorType)
+}
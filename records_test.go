@@ -0,0 +1,144 @@
+package quick
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testRecord struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// RunRecordServer serves records as NDJSON, or as a JSON text sequence on /seq.
+// On /stream, each record is flushed on its own.
+func RunRecordServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/seq":
+			w.Header().Set("Content-Type", "application/json-seq")
+			_, _ = fmt.Fprint(w, "\x1e{\"id\":1}\n\x1e{\"id\":2,\n\x1e{\"id\":3,\"name\":\"c\"}\n")
+		case "/stream":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			for i := 1; i <= 3; i++ {
+				_, _ = fmt.Fprintf(w, "{\"id\":%d}\n", i)
+				w.(http.Flusher).Flush()
+			}
+		default:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			_, _ = fmt.Fprint(w, "{\"id\":1,\"name\":\"a\"}\r\n\n{\"id\":2}\nnot json\n{\"id\":4,\"name\":\""+strings.Repeat("x", 64)+"\"}\n{\"id\":5}")
+		}
+	}))
+}
+
+func TestResponse_DecodeStream(t *testing.T) {
+	asserts := assert.New(t)
+
+	srv := RunRecordServer()
+	defer srv.Close()
+
+	resp, err := Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		records []testRecord
+		errs    []*RecordError
+	)
+	err = resp.Records().SetMaxRecordSize(32).DecodeStream(func(record testRecord, err error) error {
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			asserts.Equal(testRecord{}, record)
+			errs = append(errs, recordErr)
+			return nil
+		}
+		records = append(records, record)
+		return nil
+	})
+	asserts.NoError(err)
+	// a new record each time: record 2 has no name
+	asserts.Equal([]testRecord{{1, "a"}, {2, ""}, {5, ""}}, records)
+	if asserts.Len(errs, 2) {
+		asserts.Equal(2, errs[0].Index)
+		asserts.Equal("not json", string(errs[0].Record))
+		asserts.Equal(3, errs[1].Index)
+		asserts.True(errors.Is(errs[1], ErrRecordTooLarge))
+	}
+
+	// fn stops the decoding
+	stop := errors.New("stop")
+	count := 0
+	err = resp.DecodeStream(func(record *testRecord) error {
+		count++
+		asserts.Equal(&testRecord{1, "a"}, record)
+		return stop
+	})
+	asserts.Equal(stop, err)
+	asserts.Equal(1, count)
+
+	// without the error argument, a malformed record ends the decoding
+	records = nil
+	err = resp.DecodeStream(func(record testRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	var recordErr *RecordError
+	if asserts.True(errors.As(err, &recordErr)) {
+		asserts.Equal(2, recordErr.Index)
+	}
+	asserts.Equal([]testRecord{{1, "a"}, {2, ""}}, records)
+
+	// fn must take a record and return an error
+	invalid := []interface{}{
+		nil,
+		&records,
+		func(testRecord) {},
+		func() error { return nil },
+		func(testRecord, int) error { return nil },
+	}
+	for _, fn := range invalid {
+		asserts.Error(resp.DecodeStream(fn))
+	}
+
+	// JSON text sequence
+	resp, _ = Get(srv.URL + "/seq")
+	records = nil
+	dec := resp.Records()
+	for dec.Next() {
+		var r testRecord
+		if err := dec.Decode(&r); err != nil {
+			asserts.Equal(1, dec.Index())
+			continue
+		}
+		records = append(records, r)
+	}
+	asserts.NoError(dec.Err())
+	asserts.Equal([]testRecord{{1, ""}, {3, "c"}}, records)
+}
+
+func TestResponse_Records_Stream(t *testing.T) {
+	asserts := assert.New(t)
+
+	srv := RunRecordServer()
+	defer srv.Close()
+
+	resp, err := NewSession().SuckStream(NewRequest().SetUrl(srv.URL + "/stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Close()
+
+	var ids []int
+	err = resp.DecodeStream(func(record map[string]int) error {
+		ids = append(ids, record["id"])
+		return nil
+	})
+	asserts.NoError(err)
+	asserts.Equal([]int{1, 2, 3}, ids)
+}
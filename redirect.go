@@ -1,19 +1,145 @@
 package quick
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
-const (
-	// default redirect num
-	DefaultRedirectNum = 10
-	// request context redirect num key name
-	ContextRedirectNumKey = "redirectNum"
+// default redirect num
+const DefaultRedirectNum = 10
+
+var (
+	// ErrRedirectHost is returned when a RedirectPolicy refuses a redirect to another host.
+	ErrRedirectHost = errors.New("redirect to another host refused")
+	// ErrRedirectDowngrade is returned when a RedirectPolicy refuses a redirect from https to http.
+	ErrRedirectDowngrade = errors.New("redirect from https to http refused")
 )
 
-// redirectFunc get redirectNum from request context and check redirect number.
+// redirectPolicyKey holds the redirect policy of a request
+var redirectPolicyKey = &contextKey{"redirect-policy"}
+
+// redirectHistoryKey holds the redirect history of a request
+var redirectHistoryKey = &contextKey{"redirect-history"}
+
+// RedirectPolicy decides which redirects a request follows.
+// The number of redirects is still limited by Request.RedirectNum.
+type RedirectPolicy struct {
+	// NoFollow disables redirects: the 3xx Response is returned as-is.
+	NoFollow bool
+
+	// SameHostOnly refuses redirects to another host than the one of
+	// the original request, with ErrRedirectHost.
+	SameHostOnly bool
+
+	// RefuseDowngrade refuses redirects from https to http, with ErrRedirectDowngrade.
+	RefuseDowngrade bool
+
+	// StripHeaders lists the headers removed when a redirect goes to
+	// another host than the one of the original request.
+	//
+	// net/http already removes Authorization, WWW-Authenticate and Cookie
+	// when the new host is neither the original host nor one of its subdomains.
+	StripHeaders []string
+}
+
+// DefaultRedirectPolicy returns a policy following redirects, refusing https
+// to http downgrades and removing the Authorization header across hosts.
+func DefaultRedirectPolicy() *RedirectPolicy {
+	return &RedirectPolicy{
+		RefuseDowngrade: true,
+		StripHeaders:    []string{"Authorization"},
+	}
+}
+
+// CheckRedirect applies the policy to a redirect,
+// it can be used as http.Client CheckRedirect.
+func (p *RedirectPolicy) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if p.NoFollow {
+		return http.ErrUseLastResponse
+	}
+	if len(via) == 0 {
+		return nil
+	}
+
+	prev := via[len(via)-1]
+	if p.RefuseDowngrade && prev.URL.Scheme == "https" && req.URL.Scheme == "http" {
+		return WrapErrf(ErrRedirectDowngrade, "RedirectError: %s", req.URL.Redacted())
+	}
+
+	// net/http copies the headers of the original request to every redirect
+	if strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return nil
+	}
+	if p.SameHostOnly {
+		return WrapErrf(ErrRedirectHost, "RedirectError: %s", req.URL.Redacted())
+	}
+	for _, key := range p.StripHeaders {
+		req.Header.Del(key)
+	}
+	return nil
+}
+
+// RedirectHop is a redirect followed by a request.
+type RedirectHop struct {
+	URL        *url.URL // url of the redirect response
+	StatusCode int      // e.g. 302
+	Location   string   // Location header of the redirect response
+}
+
+// redirectHistory records the redirects followed by a request.
+type redirectHistory struct {
+	hops []RedirectHop
+}
+
+// record adds the redirect that led to req, if any.
+func (h *redirectHistory) record(req *http.Request) {
+	resp := req.Response
+	if resp == nil {
+		return
+	}
+	hop := RedirectHop{
+		StatusCode: resp.StatusCode,
+		Location:   resp.Header.Get("Location"),
+	}
+	if resp.Request != nil {
+		hop.URL = resp.Request.URL
+	}
+	h.hops = append(h.hops, hop)
+}
+
+// contextWithRedirect returns a copy of ctx with the request redirect policy and history.
+func contextWithRedirect(ctx context.Context, policy *RedirectPolicy, history *redirectHistory) context.Context {
+	if policy != nil {
+		ctx = context.WithValue(ctx, redirectPolicyKey, policy)
+	}
+	return context.WithValue(ctx, redirectHistoryKey, history)
+}
+
+// recordRedirect records the redirect that led to req in the request history.
+func recordRedirect(req *http.Request) {
+	if req.Response == nil {
+		return
+	}
+	if history, ok := req.Context().Value(redirectHistoryKey).(*redirectHistory); ok {
+		history.record(req)
+	}
+}
+
+// redirectFunc applies the request redirect policy and checks redirect number.
 func redirectFunc(req *http.Request, via []*http.Request) error {
-	redirectNum := req.Context().Value(ContextRedirectNumKey).(int)
+	if policy, ok := req.Context().Value(redirectPolicyKey).(*RedirectPolicy); ok {
+		if err := policy.CheckRedirect(req, via); err != nil {
+			return err
+		}
+	}
+
+	redirectNum, ok := RedirectNumFromContext(req.Context())
+	if !ok {
+		redirectNum = DefaultRedirectNum
+	}
 	if len(via) > redirectNum {
 		err := &RedirectError{redirectNum}
 		return WrapErr(err, "RedirectError")
@@ -0,0 +1,111 @@
+package quick
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// RunRedirectServer redirects requests with a "to" query and echoes the X-Api-Key header.
+func RunRedirectServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to := r.URL.Query().Get("to"); to != "" {
+			http.Redirect(w, r, to, http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("key=" + r.Header.Get("X-Api-Key")))
+	}))
+}
+
+func TestSession_RedirectPolicy(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunRedirectServer()
+	defer ser.Close()
+	other := RunRedirectServer()
+	defer other.Close()
+
+	header := OptionHeaderSingle("X-Api-Key", "secret")
+	// other listens on 127.0.0.1 too, use localhost to change host
+	otherURL := "http://localhost" + other.URL[len("http://127.0.0.1"):] + "/end"
+
+	// default: follow and record the history
+	session := NewSession()
+	resp, err := session.Get(ser.URL+"/a?to=/b?to=/end", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "key=secret")
+	history := resp.RedirectHistory()
+	if asserts.Len(history, 2) {
+		asserts.Equal(history[0].StatusCode, http.StatusFound)
+		asserts.Equal(history[0].URL.Path, "/a")
+		asserts.Equal(history[0].Location, "/b?to=/end")
+		asserts.Equal(history[1].URL.Path, "/b")
+		asserts.Equal(history[1].Location, "/end")
+	}
+
+	// no-follow returns the 3xx response
+	resp, err = session.Get(ser.URL+"/a?to=/end", OptionRedirectPolicy(&RedirectPolicy{NoFollow: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.StatusCode, http.StatusFound)
+	asserts.Equal(resp.GetHeaderSingle("Location"), "/end")
+	asserts.Empty(resp.RedirectHistory())
+
+	// headers are stripped across hosts
+	session.SetRedirectPolicy(&RedirectPolicy{StripHeaders: []string{"X-Api-Key"}})
+	resp, err = session.Get(ser.URL+"/a?to="+otherURL, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "key=")
+	resp, err = session.Get(ser.URL+"/a?to=/end", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "key=secret")
+
+	// same host only
+	_, err = session.Get(ser.URL+"/a?to="+otherURL, OptionRedirectPolicy(&RedirectPolicy{SameHostOnly: true}))
+	asserts.True(errors.Is(err, ErrRedirectHost))
+
+	// the redirect number is still limited
+	req := NewRequest().SetUrl(ser.URL + "/a?to=/b?to=/end")
+	req.RedirectNum = 0
+	_, err = session.Suck(req)
+	var redirectErr *RedirectError
+	asserts.True(errors.As(err, &redirectErr))
+}
+
+func TestSession_RedirectDowngrade(t *testing.T) {
+	asserts := assert.New(t)
+
+	plain := RunRedirectServer()
+	defer plain.Close()
+	tlsSer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL+"/end", http.StatusMovedPermanently)
+	}))
+	defer tlsSer.Close()
+
+	session := NewSession().InsecureSkipVerify(true)
+	resp, err := session.Get(tlsSer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.StatusCode, http.StatusOK)
+
+	_, err = session.Get(tlsSer.URL, OptionRedirectPolicy(DefaultRedirectPolicy()))
+	asserts.True(errors.Is(err, ErrRedirectDowngrade))
+}
+
+func TestRedirectFunc_MissingContext(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	asserts := assert.New(t)
+	asserts.NotPanics(func() {
+		asserts.NoError(redirectFunc(req, []*http.Request{req}))
+	})
+}
@@ -34,6 +34,13 @@ type Request struct {
 	ctx         context.Context
 	trace       bool
 	clientTrace *clientTrace
+
+	attrs          map[string]interface{} // request attributes, see SetAttr
+	redirectPolicy *RedirectPolicy
+	compress       string // request body Content-Encoding
+	rawBody        bool   // do not transcode the response body
+	charset        string // forced response charset
+	hedge          *hedge
 }
 
 // NewRequest create a request instance
@@ -246,6 +253,7 @@ func (req *Request) TraceInfo() TraceInfo {
 	// Capture remote address info when connection is non-nil
 	if ct.gotConnInfo.Conn != nil {
 		ti.RemoteAddr = ct.gotConnInfo.Conn.RemoteAddr()
+		ti.RemoteAddrFamily = addrFamily(ti.RemoteAddr)
 	}
 
 	return ti
@@ -419,15 +427,74 @@ func (req *Request) SetCookies(cookies Cookies) *Request {
 	return req
 }
 
+// SetRedirectPolicy set the redirect policy for this request,
+// it takes precedence over the session one.
+func (req *Request) SetRedirectPolicy(policy *RedirectPolicy) *Request {
+	req.redirectPolicy = policy
+	return req
+}
+
+// SetCompressBody compress the request body with coding: gzip, deflate, br or zstd.
+// The Content-Encoding header is set accordingly, a request without body is
+// sent as is.
+func (req *Request) SetCompressBody(coding string) *Request {
+	req.compress = coding
+	return req
+}
+
+// SetRawBody disable the UTF-8 transcoding of the response body,
+// Response.Body is then in the charset sent by the server.
+func (req *Request) SetRawBody(raw bool) *Request {
+	req.rawBody = raw
+	return req
+}
+
+// SetResponseCharset force the charset the response body is transcoded from,
+// instead of detecting it. This applies to any content type.
+func (req *Request) SetResponseCharset(charset string) *Request {
+	req.charset = charset
+	return req
+}
+
+// SetHedge send up to maxExtra duplicates of the request, one every delay
+// while no attempt has returned. The first attempt answering without a 5xx
+// status wins and the others are cancelled; Response.TraceInfo().RequestAttempt
+// tells which one, and the request trace is the one of that attempt. When all
+// attempts fail, the last 5xx response is returned, or else the last error.
+// Only GET, HEAD and OPTIONS requests without body are hedged.
+func (req *Request) SetHedge(delay time.Duration, maxExtra int) *Request {
+	req.hedge = &hedge{delay: delay, maxExtra: maxExtra}
+	return req
+}
+
+// SetAttr set a request attribute.
+// Attributes travel with the request: middleware reads them with
+// AttrFromContext, and the Response with GetAttr.
+func (req *Request) SetAttr(key string, value interface{}) *Request {
+	if req.attrs == nil {
+		req.attrs = make(map[string]interface{})
+	}
+	req.attrs[key] = value
+	return req
+}
+
+// GetAttr get a request attribute, nil if not set
+func (req *Request) GetAttr(key string) interface{} {
+	return req.attrs[key]
+}
+
 // Copy copy a new request
 func (req *Request) Copy() *Request {
 	// copy the URL
 	newURL, _ := CopyURL(req.URL)
 
+	// copy the body, leaving the request one unread
 	var copyBody io.Reader
 	if req.Body != nil {
-		copyBody := new(bytes.Buffer)
-		_, _ = io.Copy(copyBody, req.Body)
+		var rest io.Reader
+		if copyBody, rest = cloneBody(req.Body); rest != nil {
+			req.Body = rest
+		}
 	}
 
 	// copy the proxy url
@@ -450,10 +517,41 @@ func (req *Request) Copy() *Request {
 	newReq.Proxy = copyProxy
 	newReq.Cookies = copyCookies
 	newReq.host = req.host
+	newReq.redirectPolicy = req.redirectPolicy
+	newReq.compress = req.compress
+	newReq.rawBody = req.rawBody
+	newReq.charset = req.charset
+	newReq.hedge = req.hedge
+	newReq.trace = req.trace
+	for k, v := range req.attrs {
+		newReq.SetAttr(k, v)
+	}
 	newReq.ctx = req.ctx
 	return newReq
 }
 
+// cloneBody returns a copy of body, and the body to use in its place if
+// it was read. Bytes and strings readers and buffers are copied without
+// being read, so they can be copied concurrently; other readers are read
+// into memory and replaced.
+func cloneBody(body io.Reader) (copied, rest io.Reader) {
+	switch b := body.(type) {
+	case *bytes.Buffer:
+		return bytes.NewReader(append([]byte(nil), b.Bytes()...)), nil
+	case *bytes.Reader:
+		buf := make([]byte, b.Len())
+		_, _ = b.ReadAt(buf, b.Size()-int64(b.Len()))
+		return bytes.NewReader(buf), nil
+	case *strings.Reader:
+		buf := make([]byte, b.Len())
+		_, _ = b.ReadAt(buf, b.Size()-int64(b.Len()))
+		return bytes.NewReader(buf), nil
+	}
+	buf := new(bytes.Buffer)
+	_, _ = io.Copy(buf, body)
+	return bytes.NewReader(buf.Bytes()), bytes.NewReader(buf.Bytes())
+}
+
 // CopyURL copy a new url.URL
 func CopyURL(u *url.URL) (URL *url.URL, err error) {
 	if u == nil {
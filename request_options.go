@@ -1,6 +1,7 @@
 package quick
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 	"strings"
@@ -53,6 +54,49 @@ func OptionQueryString(v interface{}) OptionFunc {
 	}
 }
 
+// OptionCompressBody compress the request body with coding: gzip, deflate, br or zstd
+func OptionCompressBody(coding string) OptionFunc {
+	return func(req *Request) {
+		req.SetCompressBody(coding)
+	}
+}
+
+// OptionRawBody disable the UTF-8 transcoding of the response body
+func OptionRawBody() OptionFunc {
+	return func(req *Request) {
+		req.SetRawBody(true)
+	}
+}
+
+// OptionResponseCharset force the charset of the response body, e.g. "gbk"
+func OptionResponseCharset(charset string) OptionFunc {
+	return func(req *Request) {
+		req.SetResponseCharset(charset)
+	}
+}
+
+// OptionHedge hedge an idempotent request: send up to maxExtra duplicates,
+// one every delay, and take the first successful response, see Request.SetHedge
+func OptionHedge(delay time.Duration, maxExtra int) OptionFunc {
+	return func(req *Request) {
+		req.SetHedge(delay, maxExtra)
+	}
+}
+
+// OptionRedirectPolicy set the request redirect policy
+func OptionRedirectPolicy(policy *RedirectPolicy) OptionFunc {
+	return func(req *Request) {
+		req.SetRedirectPolicy(policy)
+	}
+}
+
+// OptionAttr set a request attribute
+func OptionAttr(key string, value interface{}) OptionFunc {
+	return func(req *Request) {
+		req.SetAttr(key, value)
+	}
+}
+
 // OptionBody request body for post
 func OptionBody(v interface{}) OptionFunc {
 	return func(req *Request) {
@@ -122,6 +166,13 @@ func OptionTimeout(v time.Duration) OptionFunc {
 	}
 }
 
+// OptionContext set context.Context to request
+func OptionContext(ctx context.Context) OptionFunc {
+	return func(req *Request) {
+		req.ctx = ctx
+	}
+}
+
 // OptionRedirectNum set redirect num to request
 func OptionRedirectNum(num int) OptionFunc {
 	return func(req *Request) {
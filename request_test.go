@@ -3,7 +3,9 @@ package quick
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -65,4 +67,14 @@ func TestRequest_Copy(t *testing.T) {
 	asserts.NotEqual(p1, p2)
 	asserts.NotEqual(p3, p4)
 	asserts.Equal(req1.Cookies, req2.Cookies)
+
+	// the body is copied, the request one left unread
+	req1.Body = strings.NewReader("quick")
+	req1.SetAttr("key", "value")
+	req2 = req1.Copy()
+	body, _ := ioutil.ReadAll(req2.Body)
+	asserts.Equal("quick", string(body))
+	body, _ = ioutil.ReadAll(req1.Body)
+	asserts.Equal("quick", string(body))
+	asserts.Equal("value", req2.GetAttr("key"))
 }
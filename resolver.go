@@ -0,0 +1,431 @@
+package quick
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver looks up the IP addresses of a host before the session dials it.
+// *net.Resolver satisfies this interface.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// TTLResolver is a Resolver that also reports how long
+// the addresses it returns may be cached.
+type TTLResolver interface {
+	Resolver
+	LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error)
+}
+
+// lookupIPAddr resolves host with r, reporting the lookup to httptrace.
+func lookupIPAddr(ctx context.Context, r Resolver, host string) ([]net.IPAddr, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if trace != nil && trace.DNSDone != nil {
+		trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+	}
+	return addrs, err
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// CachingResolver
+//_______________________________________________________________________
+
+// DefaultResolverTTL is how long a CachingResolver keeps the lookups of
+// a Resolver not reporting TTLs, unless set otherwise.
+const DefaultResolverTTL = time.Minute
+
+// CachingResolver caches the lookups of an underlying Resolver.
+//
+// When the underlying Resolver is a TTLResolver, entries expire with the
+// record TTL, otherwise after TTL. Failed lookups are not cached.
+type CachingResolver struct {
+	resolver Resolver
+	ttl      time.Duration
+	mu       sync.Mutex
+	entries  map[string]resolverEntry
+	now      func() time.Time
+}
+
+type resolverEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+var _ TTLResolver = (*CachingResolver)(nil)
+
+// NewCachingResolver create a CachingResolver.
+// r nil uses net.DefaultResolver; ttl is used when r does not report TTLs,
+// DefaultResolverTTL when 0.
+func NewCachingResolver(r Resolver, ttl time.Duration) *CachingResolver {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if ttl <= 0 {
+		ttl = DefaultResolverTTL
+	}
+	return &CachingResolver{
+		resolver: r,
+		ttl:      ttl,
+		entries:  make(map[string]resolverEntry),
+		now:      time.Now,
+	}
+}
+
+// LookupIPAddr returns the cached addresses of host, resolving them when missing or expired.
+func (r *CachingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, _, err := r.LookupIPAddrTTL(ctx, host)
+	return addrs, err
+}
+
+// LookupIPAddrTTL is LookupIPAddr, also returning the remaining time to live.
+func (r *CachingResolver) LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	now := r.now()
+
+	r.mu.Lock()
+	entry, ok := r.entries[host]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.addrs, entry.expires.Sub(now), nil
+	}
+
+	var (
+		addrs []net.IPAddr
+		ttl   = r.ttl
+		err   error
+	)
+	if tr, ok := r.resolver.(TTLResolver); ok {
+		addrs, ttl, err = tr.LookupIPAddrTTL(ctx, host)
+	} else {
+		addrs, err = r.resolver.LookupIPAddr(ctx, host)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r.mu.Lock()
+	if ttl > 0 {
+		r.entries[host] = resolverEntry{addrs: addrs, expires: now.Add(ttl)}
+	} else {
+		delete(r.entries, host)
+	}
+	r.mu.Unlock()
+	return addrs, ttl, nil
+}
+
+// Flush drops every cached entry.
+func (r *CachingResolver) Flush() {
+	r.mu.Lock()
+	r.entries = make(map[string]resolverEntry)
+	r.mu.Unlock()
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// DNSResolver
+//_______________________________________________________________________
+
+// DNSResolver queries DNS servers directly for A and AAAA records,
+// so the record TTLs are known. Wrap it with a CachingResolver to cache them.
+type DNSResolver struct {
+	// Servers are the "host:port" addresses of the DNS servers, tried in order.
+	Servers []string
+
+	// Timeout is the timeout of a single query. Default 5s.
+	Timeout time.Duration
+}
+
+var _ TTLResolver = (*DNSResolver)(nil)
+
+// NewDNSResolver create a DNSResolver querying servers ("host:port").
+// Without servers, the nameservers of /etc/resolv.conf are used.
+func NewDNSResolver(servers ...string) *DNSResolver {
+	if len(servers) == 0 {
+		servers = systemNameservers()
+	}
+	return &DNSResolver{
+		Servers: servers,
+		Timeout: 5 * time.Second,
+	}
+}
+
+// LookupIPAddr looks up host A and AAAA records.
+func (r *DNSResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, _, err := r.LookupIPAddrTTL(ctx, host)
+	return addrs, err
+}
+
+// LookupIPAddrTTL looks up host A and AAAA records, returning the lowest record TTL.
+func (r *DNSResolver) LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, 0, nil
+	}
+
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host}
+	}
+
+	type result struct {
+		addrs []net.IPAddr
+		ttl   uint32
+		err   error
+	}
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	results := make(chan result, len(types))
+	for _, qtype := range types {
+		go func(qtype dnsmessage.Type) {
+			addrs, ttl, err := r.query(ctx, name, qtype)
+			results <- result{addrs, ttl, err}
+		}(qtype)
+	}
+
+	var (
+		addrs    []net.IPAddr
+		ttl      uint32
+		firstErr error
+	)
+	for range types {
+		res := <-results
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		if len(res.addrs) == 0 {
+			continue
+		}
+		if len(addrs) == 0 || res.ttl < ttl {
+			ttl = res.ttl
+		}
+		addrs = append(addrs, res.addrs...)
+	}
+
+	if len(addrs) == 0 {
+		if firstErr != nil {
+			return nil, 0, firstErr
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
+
+// query sends a question to each server in turn until one answers.
+func (r *DNSResolver) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]net.IPAddr, uint32, error) {
+	if len(r.Servers) == 0 {
+		return nil, 0, &net.DNSError{Err: "no dns servers", Name: name.String()}
+	}
+
+	var lastErr error
+	for _, server := range r.Servers {
+		addrs, ttl, err := r.exchange(ctx, server, name, qtype)
+		if err == nil {
+			return addrs, ttl, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, lastErr
+}
+
+// exchange sends a question over UDP, retrying over TCP when the answer is truncated.
+func (r *DNSResolver) exchange(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) ([]net.IPAddr, uint32, error) {
+	id, err := dnsID()
+	if err != nil {
+		return nil, 0, err
+	}
+	question := dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		p dnsmessage.Parser
+		h dnsmessage.Header
+	)
+	// answers to another question are ignored, they may be spoofed
+	match := func(answer []byte) error {
+		h, err = parseDNSAnswer(&p, answer, id, question)
+		return err
+	}
+	if err := dnsExchange(ctx, "udp", server, query, match); err != nil {
+		return nil, 0, err
+	}
+	if h.Truncated {
+		if err := dnsExchange(ctx, "tcp", server, query, match); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, nil
+	default:
+		return nil, 0, &net.DNSError{Err: "server failure: " + h.RCode.String(), Name: name.String(), Server: server, IsTemporary: true}
+	}
+
+	resources, err := p.AllAnswers()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// only the records of the question name, or of its aliases, are used
+	names := map[string]bool{strings.ToLower(name.String()): true}
+	for _, res := range resources {
+		if body, ok := res.Body.(*dnsmessage.CNAMEResource); ok && names[strings.ToLower(res.Header.Name.String())] {
+			names[strings.ToLower(body.CNAME.String())] = true
+		}
+	}
+
+	var (
+		addrs []net.IPAddr
+		ttl   uint32
+	)
+	for _, res := range resources {
+		if !names[strings.ToLower(res.Header.Name.String())] {
+			continue
+		}
+		var ip net.IP
+		switch body := res.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			continue
+		}
+		if len(addrs) == 0 || res.Header.TTL < ttl {
+			ttl = res.Header.TTL
+		}
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	}
+	return addrs, ttl, nil
+}
+
+// dnsID returns a random query ID. IDs are drawn from crypto/rand,
+// predictable IDs make answers easy to spoof.
+func dnsID() (uint16, error) {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+// parseDNSAnswer starts p on answer, checking that it answers the query
+// id about question. p is then positioned on the answer records.
+func parseDNSAnswer(p *dnsmessage.Parser, answer []byte, id uint16, question dnsmessage.Question) (dnsmessage.Header, error) {
+	h, err := p.Start(answer)
+	if err != nil {
+		return h, err
+	}
+	if !h.Response || h.ID != id {
+		return h, errors.New("dns: mismatched response id")
+	}
+	q, err := p.Question()
+	if err != nil {
+		return h, err
+	}
+	if q.Type != question.Type || q.Class != question.Class || !strings.EqualFold(q.Name.String(), question.Name.String()) {
+		return h, errors.New("dns: mismatched response question")
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// dnsExchange writes a query to server and reads the answer, passed to match.
+// Over UDP, answers rejected by match are skipped until the deadline.
+// Over TCP, messages are prefixed with their length.
+func dnsExchange(ctx context.Context, network, server string, query []byte, match func(answer []byte) error) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		buf := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(buf, uint16(len(query)))
+		copy(buf[2:], query)
+		if _, err := conn.Write(buf); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		answer := make([]byte, binary.BigEndian.Uint16(buf[:2]))
+		if _, err := io.ReadFull(conn, answer); err != nil {
+			return err
+		}
+		return match(answer)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return err
+	}
+	answer := make([]byte, 65535)
+	for {
+		n, err := conn.Read(answer)
+		if err != nil {
+			return err
+		}
+		if err := match(answer[:n]); err == nil {
+			return nil
+		}
+	}
+}
+
+// systemNameservers returns the nameservers of /etc/resolv.conf.
+func systemNameservers() []string {
+	servers := make([]string, 0)
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return servers
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	return servers
+}
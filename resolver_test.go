@@ -0,0 +1,198 @@
+package quick
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type staticResolver struct {
+	addrs []net.IPAddr
+	ttl   time.Duration
+	calls int32
+}
+
+func (r *staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, _, err := r.LookupIPAddrTTL(ctx, host)
+	return addrs, err
+}
+
+func (r *staticResolver) LookupIPAddrTTL(_ context.Context, _ string) ([]net.IPAddr, time.Duration, error) {
+	atomic.AddInt32(&r.calls, 1)
+	return r.addrs, r.ttl, nil
+}
+
+func TestSession_SetHostOverride(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host + " " + r.TLS.ServerName))
+	}))
+	defer ser.Close()
+
+	// the httptest certificate is valid for example.com
+	session := NewSession()
+	session.transport.TLSClientConfig = ser.Client().Transport.(*http.Transport).TLSClientConfig
+	session.SetHostOverride("example.com:443", ser.Listener.Addr().String())
+
+	resp, err := session.Get("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "example.com example.com")
+}
+
+func TestSession_SetResolver(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+	_, port, _ := net.SplitHostPort(ser.Listener.Addr().String())
+
+	static := &staticResolver{addrs: []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, ttl: time.Minute}
+	resolver := NewCachingResolver(static, 0)
+
+	opts := DefaultSessionOptions()
+	opts.Resolver = resolver
+	opts.DisableDialKeepAlives = true
+	session := NewSession(opts).EnableTrace()
+
+	for i := 0; i < 3; i++ {
+		resp, err := session.Get("http://quick.test:" + port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asserts.Equal(resp.Body.String(), "quick")
+	}
+	asserts.Equal(atomic.LoadInt32(&static.calls), int32(1))
+}
+
+func TestCachingResolver_TTL(t *testing.T) {
+	asserts := assert.New(t)
+
+	static := &staticResolver{addrs: []net.IPAddr{{IP: net.IPv4(10, 0, 0, 5)}}, ttl: 30 * time.Second}
+	resolver := NewCachingResolver(static, time.Hour)
+
+	now := time.Now()
+	resolver.now = func() time.Time { return now }
+
+	_, ttl, err := resolver.LookupIPAddrTTL(context.Background(), "api.example.com")
+	asserts.Nil(err)
+	asserts.Equal(ttl, 30*time.Second)
+
+	now = now.Add(20 * time.Second)
+	_, ttl, _ = resolver.LookupIPAddrTTL(context.Background(), "api.example.com")
+	asserts.Equal(ttl, 10*time.Second)
+	asserts.Equal(atomic.LoadInt32(&static.calls), int32(1))
+
+	// expired after the record TTL, not the default one
+	now = now.Add(11 * time.Second)
+	_, _, _ = resolver.LookupIPAddrTTL(context.Background(), "api.example.com")
+	asserts.Equal(atomic.LoadInt32(&static.calls), int32(2))
+
+	resolver.Flush()
+	_, _, _ = resolver.LookupIPAddrTTL(context.Background(), "api.example.com")
+	asserts.Equal(atomic.LoadInt32(&static.calls), int32(3))
+}
+
+// RunDNSServer answers A questions with 127.0.0.1 and the given TTL.
+// Each answer is preceded by a spoofed one about another name, and
+// carries a record of another name.
+func RunDNSServer(t *testing.T, ttl uint32) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			other := dnsmessage.MustNewName("other.test.")
+			answer := func(question dnsmessage.Name, records ...dnsmessage.Name) []byte {
+				b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true})
+				_ = b.StartQuestions()
+				_ = b.Question(dnsmessage.Question{Name: question, Type: q.Type, Class: q.Class})
+				_ = b.StartAnswers()
+				for _, name := range records {
+					ip := [4]byte{127, 0, 0, 1}
+					if name != q.Name {
+						ip = [4]byte{127, 0, 0, 66}
+					}
+					if q.Type == dnsmessage.TypeA {
+						_ = b.AResource(
+							dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+							dnsmessage.AResource{A: ip},
+						)
+					}
+				}
+				msg, _ := b.Finish()
+				return msg
+			}
+			_, _ = conn.WriteTo(answer(other, other), addr)
+			_, _ = conn.WriteTo(answer(q.Name, q.Name, other), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSResolver(t *testing.T) {
+	asserts := assert.New(t)
+
+	resolver := NewDNSResolver(RunDNSServer(t, 42))
+	addrs, ttl, err := resolver.LookupIPAddrTTL(context.Background(), "quick.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(len(addrs), 1)
+	asserts.True(addrs[0].IP.Equal(net.IPv4(127, 0, 0, 1)))
+	asserts.Equal(ttl, 42*time.Second)
+}
+
+type plainResolver struct {
+	calls int32
+}
+
+func (r *plainResolver) LookupIPAddr(_ context.Context, _ string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&r.calls, 1)
+	return []net.IPAddr{{IP: net.IPv4(10, 0, 0, 6)}}, nil
+}
+
+func TestCachingResolver_DefaultTTL(t *testing.T) {
+	asserts := assert.New(t)
+
+	plain := &plainResolver{}
+	resolver := NewCachingResolver(plain, 0)
+	now := time.Now()
+	resolver.now = func() time.Time { return now }
+
+	_, ttl, err := resolver.LookupIPAddrTTL(context.Background(), "api.example.com")
+	asserts.Nil(err)
+	asserts.Equal(ttl, DefaultResolverTTL)
+	_, _ = resolver.LookupIPAddr(context.Background(), "api.example.com")
+	asserts.Equal(atomic.LoadInt32(&plain.calls), int32(1))
+
+	now = now.Add(DefaultResolverTTL)
+	_, _ = resolver.LookupIPAddr(context.Background(), "api.example.com")
+	asserts.Equal(atomic.LoadInt32(&plain.calls), int32(2))
+}
@@ -1,16 +1,13 @@
 package quick
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/tls"
 	"encoding/xml"
 	"errors"
-	"golang.org/x/net/html/charset"
 	"golang.org/x/text/encoding"
-	"golang.org/x/text/encoding/unicode"
-	"golang.org/x/text/transform"
 	"html"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -30,24 +27,68 @@ type Response struct {
 	ExecTime         time.Duration // request exec time
 	TLS              *tls.ConnectionState
 	TransferEncoding []string
-	Encoding         encoding.Encoding // Response body encoding
+	Encoding         encoding.Encoding // Response body encoding, encoding.Nop when not transcoded
+	Uncompressed     bool              // body was decompressed, see RawBody
+	FromCache        bool              // body was served from the session cache
+	CacheStatus      CacheStatus       // session cache status, empty without cache
+	Shared           bool              // response shared with identical requests, see Session.EnableDedup
 	clientTrace      *clientTrace
+	redirects        []RedirectHop
+	rawBody          []byte
+	content          []byte        // body before charset transcoding
+	attempt          int           // winning attempt of a hedged request
+	stream           io.ReadCloser // streamed body, see Session.SuckStream
+}
+
+// responseOptions controls how a Response is built
+type responseOptions struct {
+	decompress bool   // decode the Content-Encoding of the body
+	rawBody    bool   // do not transcode the body to UTF-8
+	charset    string // charset of the body, detected if empty
 }
 
 func BuildResponse(resp *http.Response) (*Response, error) {
+	return buildResponse(resp, responseOptions{decompress: true})
+}
+
+func buildResponse(resp *http.Response, opts responseOptions) (*Response, error) {
 	if resp == nil {
 		return nil, errors.New("http response is nil")
 	}
 
-	coding := unicode.UTF8 // HTML default encoding UTF8
-	buffReader := bufio.NewReader(resp.Body)
-	buff, err := buffReader.Peek(1024)
-	if err == nil {
-		coding, _, _ = charset.DetermineEncoding(buff, "")
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	header := CopyHeader(resp.Header)
+	contentLength := resp.ContentLength
+	uncompressed := resp.Uncompressed
+	body := raw
+	if codings := contentEncodings(header); opts.decompress && len(codings) > 0 && decodable(codings) && len(raw) > 0 {
+		body, err = decompressBytes(raw, codings)
+		if err != nil {
+			return nil, err
+		}
+		// like http.Transport, the headers no longer describe the body
+		header.Del("Content-Encoding")
+		header.Del("Content-Length")
+		contentLength = -1
+		uncompressed = true
 	}
 
-	reader := transform.NewReader(buffReader, coding.NewDecoder())
-	body, err := ioutil.ReadAll(reader)
+	// transcode text bodies to UTF-8
+	var coding encoding.Encoding = encoding.Nop
+	contentType := header.Get("Content-Type")
+	if opts.charset != "" {
+		if coding, err = lookupCharset(opts.charset); err != nil {
+			return nil, err
+		}
+	} else if !opts.rawBody && isTextContent(contentType, body) {
+		coding = detectCharset(body, contentType)
+	}
+
+	decoded, err := decodeCharset(body, coding)
 	if err != nil {
 		return nil, err
 	}
@@ -59,16 +100,29 @@ func BuildResponse(resp *http.Response) (*Response, error) {
 		Proto:            resp.Proto,
 		ProtoMajor:       resp.ProtoMajor,
 		ProtoMinor:       resp.ProtoMinor,
-		Header:           CopyHeader(resp.Header),
-		Body:             bytes.NewBuffer(body),
-		ContentLength:    resp.ContentLength,
+		Header:           header,
+		Body:             bytes.NewBuffer(decoded),
+		ContentLength:    contentLength,
 		TLS:              resp.TLS,
 		TransferEncoding: resp.TransferEncoding,
 		Encoding:         coding,
+		Uncompressed:     uncompressed,
 		clientTrace:      nil,
+		rawBody:          raw,
+		content:          body,
 	}, nil
 }
 
+// decompressBytes decodes body encoded with codings in order.
+func decompressBytes(body []byte, codings []string) ([]byte, error) {
+	reader, err := decompressReader(bytes.NewReader(body), codings)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
 func (r *Response) GetHeader() http.Header {
 	return r.Header
 }
@@ -97,6 +151,45 @@ func (r *Response) GetBody() []byte {
 	return r.Body.Bytes()
 }
 
+// Bytes returns the body after decompression, in its original charset.
+func (r *Response) Bytes() []byte {
+	return r.content
+}
+
+// Text returns the body as UTF-8 text. A body that was not transcoded,
+// see OptionRawBody, is decoded from its detected charset.
+func (r *Response) Text() string {
+	coding := r.Encoding
+	if coding == nil || coding == encoding.Nop {
+		coding = detectCharset(r.content, r.GetHeaderSingle("Content-Type"))
+	}
+	text, err := decodeCharset(r.content, coding)
+	if err != nil {
+		return string(r.content)
+	}
+	return string(text)
+}
+
+// RawBody returns the body as received, before decompression
+// and charset decoding.
+func (r *Response) RawBody() []byte {
+	return r.rawBody
+}
+
+// RedirectHistory returns the redirects followed by the request, in order.
+func (r *Response) RedirectHistory() []RedirectHop {
+	return r.redirects
+}
+
+// GetAttr get an attribute of the request, see Request.SetAttr
+func (r *Response) GetAttr(key string) interface{} {
+	if r.HttpRequest == nil {
+		return nil
+	}
+	v, _ := AttrFromContext(r.HttpRequest.Context(), key)
+	return v
+}
+
 // TraceInfo method returns the trace info for the request.
 // If either the Client or Request EnableTrace function has not been called
 // prior to the request being made, an empty TraceInfo object will be returned.
@@ -105,7 +198,7 @@ func (r *Response) GetBody() []byte {
 func (r *Response) TraceInfo() TraceInfo {
 	ct := r.clientTrace
 	if ct == nil {
-		return TraceInfo{}
+		return TraceInfo{RequestAttempt: r.attempt}
 	}
 
 	ti := TraceInfo{
@@ -143,8 +236,11 @@ func (r *Response) TraceInfo() TraceInfo {
 	// Capture remote address info when connection is non-nil
 	if ct.gotConnInfo.Conn != nil {
 		ti.RemoteAddr = ct.gotConnInfo.Conn.RemoteAddr()
+		ti.RemoteAddrFamily = addrFamily(ti.RemoteAddr)
 	}
 
+	ti.RequestAttempt = r.attempt
+
 	return ti
 }
 
@@ -153,5 +249,17 @@ func (r *Response) String() string {
 }
 
 func (r *Response) Read(p []byte) (n int, err error) {
+	if r.stream != nil {
+		return r.stream.Read(p)
+	}
 	return r.Body.Read(p)
 }
+
+// Close closes the body streamed by Session.SuckStream.
+// Responses with a buffered body have nothing to close.
+func (r *Response) Close() error {
+	if r.stream != nil {
+		return r.stream.Close()
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package quick
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -21,3 +25,59 @@ func TestBuildResponse(t *testing.T) {
 	asserts.Equal(resp.StatusCode, 200)
 	asserts.Equal(resp.Body.String(), "quick")
 }
+
+func TestResponse_Charset(t *testing.T) {
+	asserts := assert.New(t)
+
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("你好")
+	binary := []byte{0x89, 'P', 'N', 'G', 0xff, 0xfe, 0x00}
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gbk":
+			w.Header().Set("Content-Type", "text/html; charset=gbk")
+			_, _ = w.Write([]byte(gbk))
+		case "/undeclared":
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(gbk))
+		case "/binary":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(binary)
+		}
+	}))
+	defer ser.Close()
+
+	// text is transcoded
+	resp, err := Get(ser.URL + "/gbk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "你好")
+	asserts.Equal(resp.Text(), "你好")
+	asserts.Equal(resp.Bytes(), []byte(gbk))
+
+	// binary is not
+	resp, err = Get(ser.URL + "/binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.Bytes(), binary)
+	asserts.Equal(resp.Encoding, encoding.Nop)
+
+	// raw body
+	resp, err = Get(ser.URL+"/gbk", OptionRawBody())
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), gbk)
+	asserts.Equal(resp.Text(), "你好")
+
+	// forced charset
+	resp, err = Get(ser.URL+"/undeclared", OptionResponseCharset("gb18030"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "你好")
+
+	_, err = Get(ser.URL+"/gbk", OptionResponseCharset("unknown"))
+	asserts.True(errors.Is(err, ErrUnknownCharset))
+}
@@ -1,14 +1,17 @@
 package quick
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
+	"golang.org/x/net/http2"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,10 +25,30 @@ type Session struct {
 	Timeout    time.Duration
 	transport  *http.Transport
 	client     *http.Client
+	dialer     *dialer
 	middleware []HandlerFunc
-	i          int
 	log        Logger
 	trace      bool
+
+	roundTripper    http.RoundTripper // session RoundTripper, see SetTransport
+	redirectPolicy  *RedirectPolicy
+	decompress      bool
+	rateLimiter     *rateLimiter
+	circuitBreaker  *CircuitBreaker
+	bulkhead        *Bulkhead
+	cache           Cache
+	dedup           *dedup
+	proxyHandler    func(req *http.Request) (*url.URL, error)
+	proxyConfig     *ProxyConfig
+	proxyConfigFunc func(context.Context, *url.URL) (*url.URL, error)
+	proxyPool       *ProxyPool
+	proxyMu         sync.Mutex
+	socksTransports map[string]socksRoundTripper
+	h2c             *http2.Transport // h2c transport of the session transport, if any
+	http1           *http.Transport  // HTTP/1.1 clone of the session transport, see http1Transport
+
+	proxyPAC           *PAC // PAC script of the proxy config, if any
+	customProxyHandler bool // set by SetProxyHandler
 }
 
 // NewSession create a session
@@ -37,26 +60,51 @@ func NewSession(options ...*SessionOptions) *Session {
 		sessionOptions = DefaultSessionOptions()
 	}
 
-	// set transport parameters.
-	transport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   sessionOptions.DialTimeout,
-			KeepAlive: sessionOptions.DialKeepAlive,
-		}).DialContext,
-		MaxIdleConns:          sessionOptions.MaxIdleConns,
-		MaxIdleConnsPerHost:   sessionOptions.MaxIdleConnsPerHost,
-		MaxConnsPerHost:       sessionOptions.MaxConnsPerHost,
-		IdleConnTimeout:       sessionOptions.IdleConnTimeout,
-		TLSHandshakeTimeout:   sessionOptions.TLSHandshakeTimeout,
-		ExpectContinueTimeout: sessionOptions.ExpectContinueTimeout,
-		Proxy:                 proxyFunc,
+	d := newDialer(&net.Dialer{
+		Timeout:       sessionOptions.DialTimeout,
+		KeepAlive:     sessionOptions.DialKeepAlive,
+		FallbackDelay: sessionOptions.FallbackDelay,
+	})
+	d.setUnixSocket(sessionOptions.UnixSocket)
+	d.setResolver(sessionOptions.Resolver)
+	d.setFamily(sessionOptions.IPFamily, sessionOptions.FallbackDelay)
+
+	var (
+		roundTripper http.RoundTripper
+		transport    *http.Transport
+	)
+	if sessionOptions.Transport != nil {
+		roundTripper, transport = adoptTransport(sessionOptions.Transport, d)
+	} else {
+		// set transport parameters.
+		transport = &http.Transport{
+			DialContext:            d.DialContext,
+			MaxIdleConns:           sessionOptions.MaxIdleConns,
+			MaxIdleConnsPerHost:    sessionOptions.MaxIdleConnsPerHost,
+			MaxConnsPerHost:        sessionOptions.MaxConnsPerHost,
+			IdleConnTimeout:        sessionOptions.IdleConnTimeout,
+			TLSHandshakeTimeout:    sessionOptions.TLSHandshakeTimeout,
+			ExpectContinueTimeout:  sessionOptions.ExpectContinueTimeout,
+			Proxy:                  transportProxy,
+			OnProxyConnectResponse: proxyConnectResponse,
+		}
+		if sessionOptions.DisableDialKeepAlives {
+			transport.DisableKeepAlives = true
+		}
+		roundTripper = transport
 	}
-	if sessionOptions.DisableDialKeepAlives {
-		transport.DisableKeepAlives = true
+	var h2c *http2.Transport
+	if transport != nil {
+		var err error
+		if h2c, err = configureHTTP2(transport, sessionOptions); err != nil {
+			createLogger().Errorf("configure http2 fail: %s", err)
+		}
+	} else if sessionOptions.ForceHTTP1 || sessionOptions.EnableHTTP2 || sessionOptions.EnableH2C {
+		createLogger().Warnf("HTTP/2 options are ignored by a custom http.RoundTripper")
 	}
 
 	client := &http.Client{
-		Transport:     transport,
+		Transport:     roundTripper,
 		CheckRedirect: redirectFunc,
 	}
 
@@ -69,16 +117,103 @@ func NewSession(options ...*SessionOptions) *Session {
 		client.Jar = jar
 	}
 
-	// Set default user agent
-	return &Session{
-		Header:     make(http.Header),
-		client:     client,
-		transport:  transport,
-		middleware: make([]HandlerFunc, 0),
-		i:          0,
-		log:        createLogger(), // Logger
-		trace:      false,
+	session := newSessionWithClient(client, transport, d)
+	session.h2c = h2c
+	if sessionOptions.DisableDecompression {
+		session.DisableDecompression()
+	}
+	if sessionOptions.ProxyConfig != nil {
+		session.SetProxyConfig(sessionOptions.ProxyConfig)
+	}
+	return session
+}
+
+// NewSessionWithClient create a session from an existing http.Client.
+//
+// The client and its *http.Transport are copied, so later changes to the
+// session do not leak into them.
+// A nil CheckRedirect is replaced by quick's redirect handling, and a nil Jar
+// by a new cookieJar. When the client's Transport is nil, a clone of
+// http.DefaultTransport is used.
+func NewSessionWithClient(c *http.Client) *Session {
+	client := &http.Client{}
+	if c != nil {
+		*client = *c
+	}
+
+	d := newDialer(&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+
+	var transport *http.Transport
+	if client.Transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = transportProxy
+		transport.OnProxyConnectResponse = proxyConnectResponse
+		transport.DialContext = d.DialContext
+		client.Transport = transport
+	} else {
+		client.Transport, transport = adoptTransport(client.Transport, d)
+	}
+
+	if client.CheckRedirect == nil {
+		client.CheckRedirect = redirectFunc
+	}
+
+	if client.Jar == nil {
+		jar, err := NewCookieJar()
+		if err != nil {
+			return nil
+		}
+		client.Jar = jar
+	}
+
+	return newSessionWithClient(client, transport, d)
+}
+
+// newSessionWithClient create a session around a prepared http.Client.
+// transport may be nil when the client uses a custom http.RoundTripper.
+func newSessionWithClient(client *http.Client, transport *http.Transport, d *dialer) *Session {
+	session := &Session{
+		Header:       make(http.Header),
+		client:       client,
+		transport:    transport,
+		dialer:       d,
+		roundTripper: client.Transport,
+		middleware:   make([]HandlerFunc, 0),
+		log:          createLogger(), // Logger
+		trace:        false,
+		decompress:   true,
+		rateLimiter:  newRateLimiter(),
 	}
+	session.proxyHandler = session.defaultProxyHandler
+	session.SetProxyConfig(nil)
+	client.Transport = &sessionTransport{session: session}
+	return session
+}
+
+// adoptTransport returns the RoundTripper to use for rt. An *http.Transport is
+// cloned, so the caller's own transport is never modified, and the clone gets
+// quick's proxy resolution and dialer if it has none of its own; it is also
+// returned as the transport configured by the session. Any other RoundTripper
+// is returned as-is, with a nil transport.
+func adoptTransport(rt http.RoundTripper, d *dialer) (http.RoundTripper, *http.Transport) {
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		return rt, nil
+	}
+	transport = transport.Clone()
+	if transport.Proxy == nil {
+		transport.Proxy = transportProxy
+	}
+	if transport.OnProxyConnectResponse == nil {
+		transport.OnProxyConnectResponse = proxyConnectResponse
+	}
+	if transport.DialContext == nil {
+		transport.DialContext = d.DialContext
+	}
+	return transport, transport
 }
 
 // SetBaseURL method is to set Base URL in the client instance. It will be used with request
@@ -169,6 +304,10 @@ func (session *Session) Download(rawurl string, toFile string) error {
 
 // InsecureSkipVerify ssl skip verify
 func (session *Session) InsecureSkipVerify(skip bool) *Session {
+	if session.transport == nil {
+		session.log.Warnf("InsecureSkipVerify: session transport is not an *http.Transport")
+		return session
+	}
 	if session.transport.TLSClientConfig != nil {
 		session.transport.TLSClientConfig.InsecureSkipVerify = skip
 	} else {
@@ -176,9 +315,91 @@ func (session *Session) InsecureSkipVerify(skip bool) *Session {
 			InsecureSkipVerify: skip,
 		}
 	}
+	session.resetSocksTransports()
 	return session
 }
 
+// SetTransport set session http.RoundTripper.
+//
+// An *http.Transport is cloned, and the clone becomes the transport configured
+// by InsecureSkipVerify, SetProxyHandler, etc. Any other RoundTripper is used
+// as-is, and those methods keep configuring the previous *http.Transport, so a
+// RoundTripper wrapping GetTransport() still honors them.
+//
+// SOCKS proxies and WebSocket handshakes are sent by clones of the
+// *http.Transport. A wrapper having the methods Unwrap() http.RoundTripper,
+// returning the wrapped RoundTripper, and WithBase(http.RoundTripper)
+// http.RoundTripper, returning a copy wrapping another one, like
+// auth.Transport, is kept in front of those clones.
+func (session *Session) SetTransport(rt http.RoundTripper) *Session {
+	if rt == nil {
+		return session
+	}
+	rt, transport := adoptTransport(rt, session.dialer)
+	if transport != nil {
+		session.transport = transport
+	}
+	session.roundTripper = rt
+	session.resetSocksTransports()
+	return session
+}
+
+// SetDialer set session dial func used to open connections.
+// dial: func(ctx context.Context, network, addr string) (net.Conn, error)
+// nil restores the default net.Dialer.
+func (session *Session) SetDialer(dial DialFunc) *Session {
+	session.dialer.setDial(dial)
+	return session
+}
+
+// SetHostDialer set the dial func for a host, or a "host:port" address.
+// It takes precedence over SetDialer and the unix socket. nil removes it.
+func (session *Session) SetHostDialer(host string, dial DialFunc) *Session {
+	session.dialer.setHost(host, dial)
+	return session
+}
+
+// SetUnixSocket route session connections to the unix domain socket at path,
+// e.g. "/var/run/docker.sock" with "http://unix/containers/json" urls.
+// An empty path disables it.
+func (session *Session) SetUnixSocket(path string) *Session {
+	session.dialer.setUnixSocket(path)
+	return session
+}
+
+// SetHostOverride dial host ("host:port" or "host") to addr instead,
+// like curl's --resolve. The request URL, TLS server name and Host header
+// keep the original host.
+//
+//	session.SetHostOverride("api.example.com:443", "10.0.0.5:443")
+//
+// An empty addr removes the override.
+func (session *Session) SetHostOverride(host, addr string) *Session {
+	session.dialer.setOverride(host, addr)
+	return session
+}
+
+// SetResolver set session resolver used to look up host names before dialing.
+// nil restores the system resolution. See CachingResolver.
+func (session *Session) SetResolver(r Resolver) *Session {
+	session.dialer.setResolver(r)
+	return session
+}
+
+// SetIPFamily set which IP address families the session dials, and the delay
+// before racing the fallback family for PreferIPv4 and PreferIPv6. Zero means
+// DefaultFallbackDelay; a negative delay dials the fallback family only after
+// the preferred one failed.
+func (session *Session) SetIPFamily(family IPFamily, fallbackDelay time.Duration) *Session {
+	session.dialer.setFamily(family, fallbackDelay)
+	return session
+}
+
+// GetTransport get session http.RoundTripper.
+func (session *Session) GetTransport() http.RoundTripper {
+	return session.roundTripper
+}
+
 // SetHeaderSingle set session global header single
 func (session *Session) SetHeaderSingle(key, val string) *Session {
 	session.Header.Set(key, val)
@@ -250,11 +471,61 @@ func (session *Session) SetTimeout(t time.Duration) *Session {
 
 // SetProxyHandler set session global proxy handler.
 // handler: func(req *http.Request) (*url.URL, error)
+// nil restores the default handler: the request proxy, else the session ProxyConfig one.
 func (session *Session) SetProxyHandler(handler func(req *http.Request) (*url.URL, error)) *Session {
-	session.transport.Proxy = handler
+	custom := handler != nil
+	if handler == nil {
+		handler = session.defaultProxyHandler
+	}
+	session.proxyHandler = handler
+	session.proxyMu.Lock()
+	session.customProxyHandler = custom
+	session.proxyMu.Unlock()
 	return session
 }
 
+// SetProxyConfig set session proxy configuration, used by the default proxy
+// handler for requests without a request or session proxy.
+// nil reloads the configuration from the environment, see ProxyConfigFromEnvironment.
+// An invalid configuration is logged and the previous one kept.
+func (session *Session) SetProxyConfig(cfg *ProxyConfig) *Session {
+	if cfg == nil {
+		cfg = ProxyConfigFromEnvironment()
+	}
+	fn, pac, err := cfg.proxyFunc(session.dialer)
+	if err != nil {
+		session.log.Errorf("proxy config fail: %s", err)
+		return session
+	}
+
+	session.proxyMu.Lock()
+	session.proxyConfig = cfg
+	session.proxyConfigFunc = fn
+	session.proxyPAC = pac
+	session.proxyMu.Unlock()
+	return session
+}
+
+// GetProxyConfig get session proxy configuration
+func (session *Session) GetProxyConfig() *ProxyConfig {
+	session.proxyMu.Lock()
+	defer session.proxyMu.Unlock()
+	return session.proxyConfig
+}
+
+// SetProxyPool set session proxy pool. Requests without a request or session
+// proxy go through a proxy of the pool, and their outcome is reported to it.
+// nil removes the pool.
+func (session *Session) SetProxyPool(pool *ProxyPool) *Session {
+	session.proxyPool = pool
+	return session
+}
+
+// GetProxyPool get session proxy pool.
+func (session *Session) GetProxyPool() *ProxyPool {
+	return session.proxyPool
+}
+
 // SetCheckRedirectHandler set session global checkRedirect handler.
 // handler: func(req *http.Request, via []*http.Request) error
 func (session *Session) SetCheckRedirectHandler(handler func(req *http.Request, via []*http.Request) error) *Session {
@@ -262,6 +533,92 @@ func (session *Session) SetCheckRedirectHandler(handler func(req *http.Request,
 	return session
 }
 
+// SetRedirectPolicy set session global redirect policy,
+// a request policy takes precedence. nil only limits the number of redirects.
+// The policy is not applied when a CheckRedirect handler replaces quick's one.
+func (session *Session) SetRedirectPolicy(policy *RedirectPolicy) *Session {
+	session.redirectPolicy = policy
+	return session
+}
+
+// GetRedirectPolicy get session global redirect policy
+func (session *Session) GetRedirectPolicy() *RedirectPolicy {
+	return session.redirectPolicy
+}
+
+// SetRateLimit limit the session requests to rate per second, with bursts
+// of burst requests. Requests wait for their turn, or their context to be done.
+// A rate <= 0 removes the limit.
+func (session *Session) SetRateLimit(rate float64, burst int) *Session {
+	session.rateLimiter.setLimit(rate, burst)
+	return session
+}
+
+// SetHostRateLimit limit the requests to a host, or a "host:port" address,
+// in addition to the session limit. A rate <= 0 removes the limit.
+func (session *Session) SetHostRateLimit(host string, rate float64, burst int) *Session {
+	session.rateLimiter.setHostLimit(host, rate, burst)
+	return session
+}
+
+// SetAdaptiveRateLimit pause the requests to a host when its responses ask to:
+// 429 and 503 responses with a Retry-After header, or an X-RateLimit-Remaining
+// header of 0 with its X-RateLimit-Reset header. Pauses are capped, see
+// SetMaxRateLimitPause.
+func (session *Session) SetAdaptiveRateLimit(enable bool) *Session {
+	session.rateLimiter.setAdaptive(enable)
+	return session
+}
+
+// SetMaxRateLimitPause set the longest a server may pause the requests to
+// its host, so that a bogus Retry-After or X-RateLimit-Reset header does not
+// stall the session. d <= 0 restores DefaultMaxRateLimitPause.
+func (session *Session) SetMaxRateLimitPause(d time.Duration) *Session {
+	session.rateLimiter.setMaxPause(d)
+	return session
+}
+
+// SetCircuitBreaker set session circuit breaker, failing requests to
+// failing hosts immediately with ErrCircuitOpen. nil removes it.
+func (session *Session) SetCircuitBreaker(cb *CircuitBreaker) *Session {
+	session.circuitBreaker = cb
+	return session
+}
+
+// GetCircuitBreaker get session circuit breaker
+func (session *Session) GetCircuitBreaker() *CircuitBreaker {
+	return session.circuitBreaker
+}
+
+// SetBulkhead set session bulkhead, limiting the requests in flight. nil removes it.
+func (session *Session) SetBulkhead(b *Bulkhead) *Session {
+	session.bulkhead = b
+	return session
+}
+
+// GetBulkhead get session bulkhead
+func (session *Session) GetBulkhead() *Bulkhead {
+	return session.bulkhead
+}
+
+// SetCache set session response cache. nil removes it.
+//
+// GET responses are stored following the HTTP caching rules (RFC 9111) and
+// answered from the cache while fresh, see Response.FromCache. The cache is
+// shared by the requests of the session: private responses are not stored,
+// and responses to requests with an Authorization header are stored only
+// when marked public, s-maxage or must-revalidate. Streamed requests, see
+// SuckStream, bypass the cache.
+func (session *Session) SetCache(c Cache) *Session {
+	session.cache = c
+	return session
+}
+
+// GetCache get session response cache
+func (session *Session) GetCache() Cache {
+	return session.cache
+}
+
 // SetCookieJar set session global cookieJar.
 func (session *Session) SetCookieJar(jar http.CookieJar) *Session {
 	session.client.Jar = jar
@@ -298,24 +655,16 @@ func (session *Session) Use(middleware ...HandlerFunc) *Session {
 	return session
 }
 
-// next middleware
+// next runs the middleware chain on a request
 func (session *Session) next(r *http.Request) {
-	current := session.i
-	n := len(session.middleware)
-	session.i++
-	if current >= n {
-		return
-	}
+	for _, task := range session.middleware {
+		if task == nil {
+			return
+		}
 
-	task := session.middleware[current]
-	if task == nil {
-		return
+		// handler
+		task(r)
 	}
-
-	// handler
-	task(r)
-
-	session.next(r)
 }
 
 // EnableTrace method enables the Quick client trace for the requests fired from
@@ -343,6 +692,44 @@ func (session *Session) DisableTrace() *Session {
 	return session
 }
 
+// EnableDecompression method enables the decoding of gzip, deflate, br and zstd
+// response bodies, asked with the Accept-Encoding header unless the request sets it.
+// Bodies of other encodings are left as received, with their Content-Encoding.
+// Enabled by default.
+func (session *Session) EnableDecompression() *Session {
+	session.decompress = true
+	return session
+}
+
+// DisableDecompression method disables quick's decoding of response bodies.
+// The transport may still ask for and decode gzip itself, see
+// http.Transport.DisableCompression. Refer to `Session.EnableDecompression`.
+func (session *Session) DisableDecompression() *Session {
+	session.decompress = false
+	return session
+}
+
+// EnableDedup method coalesces identical GET and HEAD requests in flight into
+// a single network call, every caller getting its own copy of the Response.
+// Requests are identical when their URL and headers match: Authorization,
+// Cookie, Accept, Accept-Encoding, Accept-Language, Range and the given ones.
+// A shared response has Response.Shared set.
+//
+// Waiting requests give up when their own context ends, and get the error of
+// the request in flight when it fails, unless it failed because the context
+// of its caller ended, e.g. a shorter timeout: one of them is then sent.
+func (session *Session) EnableDedup(headers ...string) *Session {
+	session.dedup = newDedup(headers)
+	return session
+}
+
+// DisableDedup method disables the coalescing of identical requests.
+// Refer to `Session.EnableDedup`.
+func (session *Session) DisableDedup() *Session {
+	session.dedup = nil
+	return session
+}
+
 // Suck request suck data
 func (session *Session) Suck(req *Request, ops ...OptionFunc) (*Response, error) {
 	// Apply the HTTP request options
@@ -350,6 +737,11 @@ func (session *Session) Suck(req *Request, ops ...OptionFunc) (*Response, error)
 		option(req)
 	}
 
+	// hedged requests
+	if req.hedge.hedgeable(req) {
+		return session.suckHedged(req)
+	}
+
 	var (
 		ctx           context.Context
 		timeoutCancel context.CancelFunc
@@ -357,28 +749,83 @@ func (session *Session) Suck(req *Request, ops ...OptionFunc) (*Response, error)
 
 	// Set timeout to request context.
 	// Default timeout is 30s.
-	timeout := time.Second * 30
-	if req.Timeout > 0 {
-		timeout = req.Timeout
-	} else if session.Timeout > 0 {
-		timeout = session.Timeout
-	}
+	timeout := session.requestTimeout(req)
 
 	if req.ctx == nil {
 		ctx, timeoutCancel = context.WithTimeout(context.Background(), timeout)
 	} else {
 		ctx, timeoutCancel = context.WithTimeout(req.ctx, timeout)
 	}
+	// cancel the timeout context once the response body is read.
+	defer timeoutCancel()
 
+	httpRequest, err := session.newHTTPRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// start request time
+	startTime := time.Now()
+
+	// do request
+	resp, err := session.send(httpRequest, responseOptions{
+		decompress: session.decompress,
+		rawBody:    req.rawBody,
+		charset:    req.charset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// request
+	resp.RequestId = req.Id
+	// request exec time
+	resp.ExecTime = time.Now().Sub(startTime)
+	// trace info
+	resp.clientTrace = req.clientTrace
+
+	return resp, nil
+}
+
+// requestTimeout returns the timeout of req, the session timeout when not set.
+// Default timeout is 30s.
+func (session *Session) requestTimeout(req *Request) time.Duration {
+	timeout := time.Second * 30
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	} else if session.Timeout > 0 {
+		timeout = session.Timeout
+	}
+	return timeout
+}
+
+// newHTTPRequest converts req to an http.Request with context ctx,
+// merging the session settings and running the session middleware.
+func (session *Session) newHTTPRequest(ctx context.Context, req *Request) (*http.Request, error) {
 	// set proxy to request context.
 	if req.Proxy != nil {
-		ctx = context.WithValue(ctx, ContextProxyKey, req.Proxy)
+		ctx = ContextWithProxy(ctx, req.Proxy)
 	} else if session.Proxy != nil {
-		ctx = context.WithValue(ctx, ContextProxyKey, session.Proxy)
+		ctx = ContextWithProxy(ctx, session.Proxy)
 	}
 
 	// set redirectNum to request context.
-	ctx = context.WithValue(ctx, ContextRedirectNumKey, req.RedirectNum)
+	ctx = ContextWithRedirectNum(ctx, req.RedirectNum)
+
+	// set redirect policy to request context.
+	redirectPolicy := req.redirectPolicy
+	if redirectPolicy == nil {
+		redirectPolicy = session.redirectPolicy
+	}
+	history := &redirectHistory{}
+	ctx = contextWithRedirect(ctx, redirectPolicy, history)
+
+	// record the cache status to request context.
+	cacheRec := &cacheRecorder{}
+	ctx = contextWithCacheRecorder(ctx, cacheRec)
+
+	// set attributes to request context.
+	ctx = contextWithAttrs(ctx, req.attrs)
 
 	// Enable trace
 	if session.trace || req.trace {
@@ -386,15 +833,36 @@ func (session *Session) Suck(req *Request, ops ...OptionFunc) (*Response, error)
 		ctx = req.clientTrace.createContext(ctx)
 	}
 
-	// request set base url
-	if session.BaseURL != "" {
+	// request set base url, absolute urls are kept
+	if session.BaseURL != "" && !req.URL.IsAbs() {
 		rawurl := fmt.Sprintf("%s%s", session.BaseURL, req.URL)
 		if u, err := url.Parse(rawurl); err == nil {
 			req.URL = u
 		}
 	}
 
-	httpRequest, err := http.NewRequestWithContext(ctx, req.Method, req.URL.String(), req.Body)
+	// compress request body, a request without body is sent as is
+	body := req.Body
+	compressed := false
+	if req.compress != "" && req.Body != nil {
+		raw, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, WrapErr(err, "read Request Body Error")
+		}
+		// the body is read once, keep the Request reusable
+		req.Body = bytes.NewReader(raw)
+		body = req.Body
+		if len(raw) > 0 {
+			buf, err := compressBody(bytes.NewReader(raw), req.compress)
+			if err != nil {
+				return nil, WrapErr(err, "compress Request Body Error")
+			}
+			body = buf
+			compressed = true
+		}
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, req.Method, req.URL.String(), body)
 	if err != nil {
 		return nil, err
 	}
@@ -418,45 +886,17 @@ func (session *Session) Suck(req *Request, ops ...OptionFunc) (*Response, error)
 
 	// merge request header and session header
 	httpRequest.Header = MergeHeaders(session.Header, req.Header)
-
-	// middleware
-	session.next(httpRequest)
-
-	// start request time
-	startTime := time.Now()
-
-	// do request
-	httpResponse, err := session.client.Do(httpRequest)
-	defer func() {
-		if httpResponse != nil && httpResponse.Body != nil {
-			_ = httpResponse.Body.Close()
-		}
-	}()
-
-	if err != nil {
-		// check timeout error
-		if strings.Contains(err.Error(), "context deadline exceeded") {
-			return nil, WrapErr(ErrTimeout, err.Error())
-		}
-		return nil, WrapErr(err, "Request Error")
+	if compressed {
+		httpRequest.Header.Set("Content-Encoding", strings.ToLower(req.compress))
 	}
-
-	resp, err := BuildResponse(httpResponse)
-	if err != nil {
-		return nil, WrapErr(err, "build Response Error")
+	if session.decompress {
+		setAcceptEncoding(httpRequest)
 	}
 
-	// request
-	resp.RequestId = req.Id
-	// request exec time
-	resp.ExecTime = time.Now().Sub(startTime)
-	// trace info
-	resp.clientTrace = req.clientTrace
-
-	// cancel the timeout context after request successful.
-	timeoutCancel()
+	// middleware
+	session.next(httpRequest)
 
-	return resp, nil
+	return httpRequest, nil
 }
 
 // Do send http.Request
@@ -468,8 +908,8 @@ func (session *Session) Do(req *http.Request) (*Response, error) {
 		timeout = session.Timeout
 	}
 
-	// request set base url
-	if session.BaseURL != "" {
+	// request set base url, absolute urls are kept
+	if session.BaseURL != "" && !req.URL.IsAbs() {
 		rawurl := fmt.Sprintf("%s%s", session.BaseURL, req.URL)
 		if u, err := url.Parse(rawurl); err == nil {
 			req.URL = u
@@ -478,13 +918,22 @@ func (session *Session) Do(req *http.Request) (*Response, error) {
 	}
 
 	ctx, timeoutCancel := context.WithTimeout(context.Background(), timeout)
+	defer timeoutCancel()
 
 	if session.Proxy != nil {
-		ctx = context.WithValue(ctx, ContextProxyKey, session.Proxy)
+		ctx = ContextWithProxy(ctx, session.Proxy)
 	}
 
 	// set redirectNum to request context.
-	ctx = context.WithValue(ctx, ContextRedirectNumKey, DefaultRedirectNum)
+	ctx = ContextWithRedirectNum(ctx, DefaultRedirectNum)
+
+	// set redirect policy to request context.
+	history := &redirectHistory{}
+	ctx = contextWithRedirect(ctx, session.redirectPolicy, history)
+
+	// record the cache status to request context.
+	cacheRec := &cacheRecorder{}
+	ctx = contextWithCacheRecorder(ctx, cacheRec)
 
 	// Enable trace
 	var ct *clientTrace
@@ -495,6 +944,9 @@ func (session *Session) Do(req *http.Request) (*Response, error) {
 
 	// merge request header and session header
 	req.Header = MergeHeaders(session.Header, req.Header)
+	if session.decompress {
+		setAcceptEncoding(req)
+	}
 
 	req = req.WithContext(ctx)
 
@@ -505,6 +957,32 @@ func (session *Session) Do(req *http.Request) (*Response, error) {
 	startTime := time.Now()
 
 	// http.Client send request
+	resp, err := session.send(req, responseOptions{decompress: session.decompress})
+	if err != nil {
+		return nil, err
+	}
+
+	// request
+	resp.clientTrace = ct
+	// request exec time
+	resp.ExecTime = time.Now().Sub(startTime)
+
+	return resp, nil
+}
+
+// send sends req with the session http.Client and builds its Response.
+// Identical requests in flight share their response when deduplication is enabled.
+func (session *Session) send(req *http.Request, opts responseOptions) (*Response, error) {
+	if d := session.dedup; d != nil && dedupable(req) {
+		return d.do(req, opts, func() (*Response, error) {
+			return session.fetch(req, opts)
+		})
+	}
+	return session.fetch(req, opts)
+}
+
+// fetch sends req with the session http.Client and builds its Response.
+func (session *Session) fetch(req *http.Request, opts responseOptions) (*Response, error) {
 	httpResponse, err := session.client.Do(req)
 	defer func() {
 		if httpResponse == nil {
@@ -526,18 +1004,20 @@ func (session *Session) Do(req *http.Request) (*Response, error) {
 		return nil, WrapErr(err, "Request Error")
 	}
 
-	resp, err := BuildResponse(httpResponse)
+	resp, err := buildResponse(httpResponse, opts)
 	if err != nil {
 		return nil, WrapErr(err, "build Response Error")
 	}
 
-	// request
-	resp.clientTrace = ct
-	// request exec time
-	resp.ExecTime = time.Now().Sub(startTime)
-
-	// cancel the timeout context after request successed.
-	timeoutCancel()
+	// followed redirects
+	if history, ok := req.Context().Value(redirectHistoryKey).(*redirectHistory); ok {
+		resp.redirects = history.hops
+	}
+	// cache status
+	if rec, ok := req.Context().Value(cacheStatusKey).(*cacheRecorder); ok {
+		resp.CacheStatus = rec.status
+		resp.FromCache = rec.fromCache()
+	}
 
 	return resp, nil
 }
@@ -1,8 +1,28 @@
 package quick
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 type SessionOptions struct {
+	// Transport specifies the http.RoundTripper used by the session.
+	// If nil, the session builds an *http.Transport from the options below.
+	//
+	// When Transport is an *http.Transport, the session uses a clone of it.
+	// Without a Proxy, quick's proxy resolution is installed on the clone,
+	// so request proxies keep working.
+	// Any other RoundTripper is used as-is.
+	Transport http.RoundTripper
+
+	// ProxyConfig is the proxy configuration of the session.
+	// If nil, it is read from the environment, see ProxyConfigFromEnvironment.
+	ProxyConfig *ProxyConfig
+
+	// DisableDecompression disables the decoding of compressed
+	// response bodies, see Session.EnableDecompression.
+	DisableDecompression bool
+
 	// DialTimeout is the maximum amount of time a dial will wait for
 	// a connect to complete.
 	//
@@ -66,6 +86,58 @@ type SessionOptions struct {
 	//
 	// This is unrelated to the similarly named TCP keep-alives.
 	DisableDialKeepAlives bool
+
+	// UnixSocket, if non-empty, is the path of a unix domain socket
+	// every connection of the session is dialed to, e.g.
+	// "/var/run/docker.sock" with "http://unix/containers/json" urls.
+	UnixSocket string
+
+	// Resolver, if non-nil, looks up host names before dialing
+	// instead of the system resolution. See CachingResolver.
+	Resolver Resolver
+
+	// IPFamily controls which IP address families are dialed:
+	// any (default), IPv4 or IPv6 only, or prefer one of them.
+	IPFamily IPFamily
+
+	// FallbackDelay specifies the length of time to wait before
+	// racing the fallback address family when dialing a dual-stack
+	// host (Happy Eyeballs). Zero means DefaultFallbackDelay.
+	// If negative, the fallback family is dialed only after the
+	// preferred one failed.
+	FallbackDelay time.Duration
+
+	// ForceHTTP1, if true, disables HTTP/2 and every request
+	// uses HTTP/1.1. It takes precedence over EnableHTTP2.
+	//
+	// ForceHTTP1, EnableHTTP2 and EnableH2C apply to the transport built
+	// by the session or to a clone of an *http.Transport given as Transport.
+	// They are ignored, with a warning, for any other RoundTripper.
+	ForceHTTP1 bool
+
+	// EnableHTTP2, if true, configures HTTP/2 over TLS explicitly
+	// with golang.org/x/net/http2, using the HTTP2 settings below.
+	EnableHTTP2 bool
+
+	// EnableH2C, if true, sends requests for "http" URLs as
+	// cleartext HTTP/2 with prior knowledge (h2c). The server must
+	// speak HTTP/2 on that port. HTTP proxies are not used for them,
+	// SOCKS proxies are.
+	EnableH2C bool
+
+	// HTTP2ReadIdleTimeout is the timeout after which a health check
+	// using a ping frame is carried out if no frame is received on an
+	// HTTP/2 connection. Zero means no health check is performed.
+	HTTP2ReadIdleTimeout time.Duration
+
+	// HTTP2PingTimeout is the timeout after which an HTTP/2 connection
+	// is closed if a response to a ping is not received.
+	// Zero means the http2 default (15s).
+	HTTP2PingTimeout time.Duration
+
+	// HTTP2MaxReadFrameSize is the largest HTTP/2 frame the client is
+	// willing to read. Zero means the http2 default (16KB).
+	HTTP2MaxReadFrameSize uint32
 }
 
 // DefaultSessionOptions return a default SessionOptions object.
@@ -81,5 +153,13 @@ func DefaultSessionOptions() *SessionOptions {
 		ExpectContinueTimeout: 1 * time.Second,
 		DisableCookieJar:      false,
 		DisableDialKeepAlives: false,
+		ForceHTTP1:            false,
+		EnableHTTP2:           false,
+		EnableH2C:             false,
+		UnixSocket:            "",
+		Resolver:              nil,
+		IPFamily:              IPFamilyAny,
+		FallbackDelay:         DefaultFallbackDelay,
+		Transport:             nil,
 	}
 }
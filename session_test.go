@@ -1,10 +1,12 @@
 package quick
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 )
 
@@ -96,3 +98,158 @@ func TestSession_EnableTrace(t *testing.T) {
 	resp, _ := session.EnableTrace().Get("https://httpbin.org/get")
 	fmt.Println(resp.TraceInfo())
 }
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestSession_SetTransport(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+
+	session := NewSession().SetHeaderSingle("X-Session", "1")
+	base := session.GetTransport()
+
+	var seen *http.Request
+	session.SetTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		seen = r
+		return base.RoundTrip(r)
+	}))
+
+	resp, err := session.Get(ser.URL, OptionCookies(NewCookiesWithString("sid=1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "quick")
+	asserts.NotNil(seen)
+	asserts.Equal(seen.Header.Get("X-Session"), "1")
+
+	// the wrapped *http.Transport is still the one configured by the session
+	session.InsecureSkipVerify(true)
+	asserts.True(base.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+}
+
+func TestSession_OptionsTransport(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+
+	opts := DefaultSessionOptions()
+	transport := &http.Transport{}
+	opts.Transport = transport
+
+	session := NewSession(opts)
+	resp, err := session.Get(ser.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.StatusCode, 200)
+	// the session configures a clone of the transport
+	asserts.Nil(transport.Proxy)
+	asserts.NotNil(session.GetTransport().(*http.Transport).Proxy)
+}
+
+func TestNewSessionWithClient(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "quick"})
+			http.Redirect(w, r, "/cookie", http.StatusFound)
+			return
+		}
+		c, err := r.Cookie("sid")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(c.Value))
+	}))
+	defer ser.Close()
+
+	client := &http.Client{}
+	session := NewSessionWithClient(client)
+
+	resp, err := session.Get(ser.URL + "/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.StatusCode, 200)
+	asserts.Equal(resp.Body.String(), "quick")
+	asserts.Nil(client.Jar)
+	asserts.Nil(client.Transport)
+
+	// the transport of the client is left untouched
+	transport := &http.Transport{}
+	session = NewSessionWithClient(&http.Client{Transport: transport})
+	session.InsecureSkipVerify(true)
+	resp, err = session.Get(ser.URL + "/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal(resp.Body.String(), "quick")
+	asserts.Nil(transport.Proxy)
+	asserts.Nil(transport.DialContext)
+	asserts.False(transport.TLSClientConfig != nil && transport.TLSClientConfig.InsecureSkipVerify)
+}
+
+func TestSession_Middleware(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunServer()
+	defer ser.Close()
+
+	proxyURL, _ := url.Parse("http://127.0.0.1:1")
+	var (
+		calls       []interface{}
+		redirectNum int
+		proxy       *url.URL
+	)
+	session := NewSession().Use(
+		func(r *http.Request) {
+			v, _ := AttrFromContext(r.Context(), "name")
+			calls = append(calls, v)
+		},
+		func(r *http.Request) {
+			redirectNum, _ = RedirectNumFromContext(r.Context())
+			proxy, _ = ProxyFromContext(r.Context())
+		},
+	)
+
+	for i := 0; i < 2; i++ {
+		resp, err := session.Get(ser.URL, OptionAttr("name", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		asserts.Equal(resp.GetAttr("name"), i)
+		asserts.Nil(resp.GetAttr("missing"))
+	}
+	// middleware runs on every request
+	asserts.Equal(calls, []interface{}{0, 1})
+	asserts.Equal(redirectNum, DefaultRedirectNum)
+	asserts.Nil(proxy)
+
+	req := NewRequest().SetUrl(ser.URL).SetAttr("name", "req")
+	req.RedirectNum = 3
+	req.Proxy = proxyURL
+	_, _ = session.Suck(req)
+	asserts.Equal(calls[2], "req")
+	asserts.Equal(redirectNum, 3)
+	asserts.Equal(proxy, proxyURL)
+	asserts.Equal(req.Copy().GetAttr("name"), "req")
+
+	// the deprecated keys are still honoured
+	ctx := context.WithValue(context.Background(), ContextProxyKey, proxyURL)
+	ctx = context.WithValue(ctx, ContextRedirectNumKey, 5)
+	_, _ = session.Get(ser.URL, OptionContext(ctx))
+	asserts.Equal(redirectNum, DefaultRedirectNum)
+	asserts.Equal(proxy, proxyURL)
+	n, ok := RedirectNumFromContext(ctx)
+	asserts.True(ok)
+	asserts.Equal(n, 5)
+}
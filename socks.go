@@ -0,0 +1,288 @@
+package quick
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SOCKS proxy url schemes supported by the session.
+//
+// "socks5" and "socks4" resolve the target host locally,
+// "socks5h" and "socks4a" let the proxy resolve it.
+const (
+	ProxySchemeSOCKS4  = "socks4"
+	ProxySchemeSOCKS4A = "socks4a"
+	ProxySchemeSOCKS5  = "socks5"
+	ProxySchemeSOCKS5H = "socks5h"
+)
+
+// isSocksScheme reports whether scheme is a SOCKS proxy scheme.
+func isSocksScheme(scheme string) bool {
+	switch scheme {
+	case ProxySchemeSOCKS4, ProxySchemeSOCKS4A, ProxySchemeSOCKS5, ProxySchemeSOCKS5H:
+		return true
+	}
+	return false
+}
+
+// socksDialer dials connections through a SOCKS proxy.
+type socksDialer struct {
+	proxy  *url.URL
+	dialer *dialer
+}
+
+// DialContext connects to the proxy with the session dialer and asks it to connect to addr.
+func (s *socksDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyAddr := s.proxy.Host
+	if s.proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(s.proxy.Hostname(), schemePort(s.proxy.Scheme))
+	}
+
+	conn, err := s.dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, &ProxyError{Proxy: s.proxy, Err: err}
+	}
+
+	// bound the handshake with the context deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	target := s.dialer.overrideAddr(addr)
+	switch s.proxy.Scheme {
+	case ProxySchemeSOCKS5, ProxySchemeSOCKS5H:
+		err = s.connect5(ctx, conn, target)
+	default:
+		err = s.connect4(ctx, conn, target)
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, &ProxyError{Proxy: s.proxy, Err: err}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// resolve returns the IP of host, resolved locally with the session dialer.
+func (s *socksDialer) resolve(ctx context.Context, host string, ipv4 bool) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	addrs, err := s.dialer.lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if !ipv4 || addr.IP.To4() != nil {
+			return addr.IP, nil
+		}
+	}
+	return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+}
+
+// connect5 performs a SOCKS5 handshake (RFC 1928),
+// with username/password authentication (RFC 1929) when the proxy url has a user.
+func (s *socksDialer) connect5(ctx context.Context, conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{0x00}
+	if s.proxy.User != nil {
+		methods = append(methods, 0x02)
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("%w: unexpected socks version %d", ErrProxyRejected, reply[0])
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if s.proxy.User == nil {
+			return ErrProxyAuth
+		}
+		username := s.proxy.User.Username()
+		password, _ := s.proxy.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("%w: username or password too long", ErrProxyAuth)
+		}
+		req := []byte{0x01, byte(len(username))}
+		req = append(req, username...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return ErrProxyAuth
+		}
+	default:
+		return fmt.Errorf("%w: no acceptable authentication methods", ErrProxyAuth)
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if s.proxy.Scheme == ProxySchemeSOCKS5H && net.ParseIP(host) == nil {
+		if len(host) > 255 {
+			return errors.New("socks: host name too long")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else {
+		ip, err := s.resolve(ctx, host, false)
+		if err != nil {
+			return err
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, 0x01)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, 0x04)
+			req = append(req, ip.To16()...)
+		}
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		return fmt.Errorf("%w: %s", ErrProxyRejected, socks5Reply(head[1]))
+	}
+
+	// discard the bound address
+	var skip int
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("%w: unknown address type %d", ErrProxyRejected, head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// connect4 performs a SOCKS4 or SOCKS4a handshake.
+// The proxy url username is sent as the user id.
+func (s *socksDialer) connect4(ctx context.Context, conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	req := []byte{0x04, 0x01, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(port))
+
+	var domain string
+	if s.proxy.Scheme == ProxySchemeSOCKS4A && net.ParseIP(host) == nil {
+		// 0.0.0.x tells the proxy a domain name follows the user id
+		req = append(req, 0, 0, 0, 1)
+		domain = host
+	} else {
+		ip, err := s.resolve(ctx, host, true)
+		if err != nil {
+			return err
+		}
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return fmt.Errorf("%w: socks4 does not support IPv6", ErrProxyRejected)
+		}
+		req = append(req, ip4...)
+	}
+	if s.proxy.User != nil {
+		req = append(req, s.proxy.User.Username()...)
+	}
+	req = append(req, 0)
+	if domain != "" {
+		req = append(req, domain...)
+		req = append(req, 0)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch reply[1] {
+	case 0x5a:
+		return nil
+	case 0x5c, 0x5d:
+		return fmt.Errorf("%w: socks4 identd rejected the user id", ErrProxyAuth)
+	default:
+		return fmt.Errorf("%w: socks4 request rejected or failed", ErrProxyRejected)
+	}
+}
+
+// socks5Reply returns the description of a SOCKS5 reply code.
+func socks5Reply(code byte) string {
+	switch code {
+	case 0x01:
+		return "general SOCKS server failure"
+	case 0x02:
+		return "connection not allowed by ruleset"
+	case 0x03:
+		return "network unreachable"
+	case 0x04:
+		return "host unreachable"
+	case 0x05:
+		return "connection refused"
+	case 0x06:
+		return "TTL expired"
+	case 0x07:
+		return "command not supported"
+	case 0x08:
+		return "address type not supported"
+	}
+	return "unknown reply code " + strconv.Itoa(int(code))
+}
@@ -0,0 +1,293 @@
+package quick
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEventStream is returned when a server does not answer with an event stream
+var ErrEventStream = errors.New("not an event stream")
+
+const (
+	// DefaultEventRetry is the reconnection time of event streams until the server sets it
+	DefaultEventRetry = 3 * time.Second
+	// maxEventLine is the max size of an event stream line
+	maxEventLine = 1 << 20
+)
+
+// Event is a Server-Sent Event
+type Event struct {
+	ID    string        // last event ID of the stream
+	Type  string        // event field, "message" when not set
+	Data  string        // data fields, joined by "\n"
+	Retry time.Duration // retry field, 0 when not set
+}
+
+// EventStream reads the events of a Server-Sent Events stream, see Session.SSE.
+//
+//	stream, err := session.SSE("https://example.com/events")
+//	if err != nil {
+//		...
+//	}
+//	defer stream.Close()
+//	for stream.Next() {
+//		event := stream.Event()
+//		...
+//	}
+//	if err := stream.Err(); err != nil {
+//		...
+//	}
+type EventStream struct {
+	session     *Session
+	req         *Request
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	resp        *Response
+	scanner     *bufio.Scanner
+	event       Event
+	lastEventID string
+	retry       time.Duration
+	bom         bool // a byte order mark may start the stream
+	done        bool
+	err         error
+}
+
+// SSE connects to the Server-Sent Events stream at rawurl.
+//
+// When the connection drops, the stream reconnects after the retry interval
+// of the server, DefaultEventRetry until set, sending the Last-Event-ID header.
+// The stream ends when the request context ends, on Close, when the server
+// answers 204 No Content, or with an error when it answers anything but an
+// event stream. The request timeout applies to each connection until its
+// headers are received.
+func (session *Session) SSE(rawurl string, ops ...OptionFunc) (*EventStream, error) {
+	req := NewRequest().SetMethod(http.MethodGet).SetUrl(rawurl)
+	for _, option := range ops {
+		option(req)
+	}
+
+	parent := req.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	s := &EventStream{
+		session: session,
+		req:     req,
+		ctx:     ctx,
+		cancel:  cancel,
+		retry:   DefaultEventRetry,
+	}
+	if _, err := s.connect(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect opens a connection to the stream. The error is retryable
+// unless the server refused the stream.
+func (s *EventStream) connect() (retryable bool, err error) {
+	req := s.req.Copy()
+	req.ctx = s.ctx
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+
+	resp, err := s.session.SuckStream(req)
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		_ = resp.Close()
+		s.done = true
+		return false, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.GetContextType())
+	if resp.StatusCode != http.StatusOK || mediaType != "text/event-stream" {
+		_ = resp.Close()
+		return false, WrapErrf(ErrEventStream, "%s %s", resp.Status, resp.GetContextType())
+	}
+
+	s.resp = resp
+	s.bom = true
+	s.scanner = bufio.NewScanner(resp)
+	s.scanner.Buffer(make([]byte, 4096), maxEventLine)
+	s.scanner.Split(scanEventLines)
+	return false, nil
+}
+
+// Next reads the next event, reconnecting when the connection drops.
+// It returns false when the stream ended.
+func (s *EventStream) Next() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.done {
+		if s.scanner == nil {
+			// reconnect after the retry interval
+			timer := time.NewTimer(s.retry)
+			select {
+			case <-s.ctx.Done():
+				timer.Stop()
+				s.done = true
+				continue
+			case <-timer.C:
+			}
+			retryable, err := s.connect()
+			if err != nil && !retryable {
+				s.err = err
+				s.done = true
+			}
+			continue
+		}
+
+		if s.read() {
+			return true
+		}
+		if err := s.scanner.Err(); err == bufio.ErrTooLong {
+			s.err = WrapErr(err, "event stream line too long")
+			s.done = true
+		}
+		// connection dropped, the pending event is discarded
+		_ = s.resp.Close()
+		s.resp, s.scanner = nil, nil
+		if s.ctx.Err() != nil {
+			s.done = true
+		}
+	}
+
+	if s.resp != nil {
+		_ = s.resp.Close()
+		s.resp, s.scanner = nil, nil
+	}
+	return false
+}
+
+// read parses the stream until an event is dispatched, see
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+func (s *EventStream) read() bool {
+	var (
+		data    strings.Builder
+		hasData bool
+		event   Event
+	)
+	for s.scanner.Scan() {
+		line := s.scanner.Bytes()
+		if s.bom {
+			line = bytes.TrimPrefix(line, []byte("\ufeff"))
+			s.bom = false
+		}
+
+		// dispatch the event
+		if len(line) == 0 {
+			if !hasData {
+				event = Event{}
+				continue
+			}
+			event.ID = s.lastEventID
+			event.Data = data.String()
+			if event.Type == "" {
+				event.Type = "message"
+			}
+			s.event = event
+			return true
+		}
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := string(line), ""
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field = string(line[:i])
+			value = strings.TrimPrefix(string(line[i+1:]), " ")
+		}
+		switch field {
+		case "event":
+			event.Type = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				s.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				s.retry = time.Duration(ms) * time.Millisecond
+				event.Retry = s.retry
+			}
+		}
+	}
+	return false
+}
+
+// Event returns the current event.
+func (s *EventStream) Event() Event {
+	return s.event
+}
+
+// LastEventID returns the last event ID received.
+func (s *EventStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Err returns the error that ended the stream, if any.
+func (s *EventStream) Err() error {
+	return s.err
+}
+
+// Close ends the stream. It may be called while Next is waiting.
+func (s *EventStream) Close() error {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	if s.resp != nil {
+		_ = s.resp.Close()
+		s.resp, s.scanner = nil, nil
+	}
+	return nil
+}
+
+// scanEventLines is a bufio.SplitFunc for the lines of an event stream,
+// ended by "\r\n", "\n" or "\r".
+func scanEventLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		// a "\n" may follow
+		if !atEOF {
+			return 0, nil, nil
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,118 @@
+package quick
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// RunEventServer serves an event stream over three connections:
+// two events then a dropped connection, the event after Last-Event-ID,
+// then 204 No Content.
+func RunEventServer(lastEventIDs chan<- string) *httptest.Server {
+	var conns int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&conns, 1)
+		lastEventIDs <- r.Header.Get("Last-Event-ID")
+		if n == 3 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		switch n {
+		case 1:
+			_, _ = fmt.Fprint(w, "\ufeff: comment\nretry: 10\n\n")
+			_, _ = fmt.Fprint(w, "id: 1\nevent: greeting\ndata: hello\ndata:  world\n\n")
+			_, _ = fmt.Fprint(w, "id: 2\r\ndata: second\r\n\r\n")
+			_, _ = fmt.Fprint(w, "data: incomplete\n")
+		case 2:
+			_, _ = fmt.Fprint(w, "data: third\rid\r\r")
+		}
+	}))
+}
+
+func TestSession_SSE(t *testing.T) {
+	asserts := assert.New(t)
+
+	lastEventIDs := make(chan string, 3)
+	srv := RunEventServer(lastEventIDs)
+	defer srv.Close()
+
+	stream, err := NewSession().SSE(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var events []Event
+	for stream.Next() {
+		events = append(events, stream.Event())
+	}
+	asserts.NoError(stream.Err())
+	asserts.Equal([]Event{
+		{ID: "1", Type: "greeting", Data: "hello\n world"},
+		{ID: "2", Type: "message", Data: "second"},
+		{ID: "", Type: "message", Data: "third"},
+	}, events)
+
+	asserts.Equal("", <-lastEventIDs)
+	asserts.Equal("2", <-lastEventIDs)
+	asserts.Equal("", <-lastEventIDs)
+}
+
+func TestSession_SSE_Close(t *testing.T) {
+	asserts := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/html" {
+			_, _ = fmt.Fprint(w, "<html></html>")
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	_, err := NewSession().SSE(srv.URL + "/html")
+	asserts.True(errors.Is(err, ErrEventStream))
+
+	// closed while waiting for an event
+	stream, err := NewSession().SSE(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.True(stream.Next())
+	time.AfterFunc(50*time.Millisecond, func() { _ = stream.Close() })
+	asserts.False(stream.Next())
+	asserts.NoError(stream.Err())
+
+	// context shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err = NewSession().SSE(srv.URL, OptionContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.True(stream.Next())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	asserts.False(stream.Next())
+}
+
+func TestScanEventLines(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("a\nb\r\nc\rd"))
+	scanner.Split(scanEventLines)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, lines)
+}
@@ -0,0 +1,140 @@
+package quick
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/text/encoding"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SuckStream sends req and returns its response as soon as the headers are
+// received: the body is streamed by Response.Read, and must be closed with
+// Response.Close. The request timeout applies until the headers are received,
+// the body is read until the request context ends.
+//
+// Compressed bodies are decompressed on the fly, unless decompression is
+// disabled, but are not transcoded to UTF-8.
+func (session *Session) SuckStream(req *Request, ops ...OptionFunc) (*Response, error) {
+	// Apply the HTTP request options
+	for _, option := range ops {
+		option(req)
+	}
+
+	// start request time
+	startTime := time.Now()
+
+	httpResponse, cancel, err := session.open(req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := newStreamResponse(httpResponse, session.decompress, cancel)
+	if err != nil {
+		_ = httpResponse.Body.Close()
+		cancel()
+		return nil, WrapErr(err, "build Response Error")
+	}
+	if history, ok := httpResponse.Request.Context().Value(redirectHistoryKey).(*redirectHistory); ok {
+		resp.redirects = history.hops
+	}
+	if rec, ok := httpResponse.Request.Context().Value(cacheStatusKey).(*cacheRecorder); ok {
+		resp.CacheStatus = rec.status
+		resp.FromCache = rec.fromCache()
+	}
+	resp.RequestId = req.Id
+	resp.ExecTime = time.Now().Sub(startTime)
+	resp.clientTrace = req.clientTrace
+	return resp, nil
+}
+
+// open sends req and returns its response without reading the body.
+// prepare, when not nil, adjusts the http.Request before it is sent.
+// The request timeout applies until the headers are received, cancel
+// ends the request context once the body is no longer used.
+func (session *Session) open(req *Request, prepare func(*http.Request)) (resp *http.Response, cancel context.CancelFunc, err error) {
+	parent := req.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	// streamed bodies are not buffered for the cache
+	ctx, cancel := context.WithCancel(context.WithValue(parent, noCacheKey, true))
+
+	httpRequest, err := session.newHTTPRequest(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	if prepare != nil {
+		prepare(httpRequest)
+	}
+
+	timer := time.AfterFunc(session.requestTimeout(req), cancel)
+	resp, err = session.client.Do(httpRequest)
+	if !timer.Stop() {
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		cancel()
+		return nil, nil, WrapErr(ErrTimeout, "response headers not received in time")
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, WrapErr(err, "Request Error")
+	}
+	return resp, cancel, nil
+}
+
+// newStreamResponse builds a Response streaming the body of resp.
+// cancel is called once the body is closed.
+func newStreamResponse(resp *http.Response, decompress bool, cancel context.CancelFunc) (*Response, error) {
+	header := CopyHeader(resp.Header)
+	contentLength := resp.ContentLength
+	uncompressed := resp.Uncompressed
+	var body io.Reader = resp.Body
+	if codings := contentEncodings(header); decompress && len(codings) > 0 && decodable(codings) {
+		decoded, err := decompressReader(resp.Body, codings)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+		header.Del("Content-Encoding")
+		header.Del("Content-Length")
+		contentLength = -1
+		uncompressed = true
+	}
+
+	return &Response{
+		HttpRequest:      resp.Request,
+		Status:           resp.Status,
+		StatusCode:       resp.StatusCode,
+		Proto:            resp.Proto,
+		ProtoMajor:       resp.ProtoMajor,
+		ProtoMinor:       resp.ProtoMinor,
+		Header:           header,
+		Body:             new(bytes.Buffer),
+		ContentLength:    contentLength,
+		TLS:              resp.TLS,
+		TransferEncoding: resp.TransferEncoding,
+		Encoding:         encoding.Nop,
+		Uncompressed:     uncompressed,
+		stream:           &streamBody{Reader: body, body: resp.Body, cancel: cancel},
+	}, nil
+}
+
+// streamBody is a streamed response body
+type streamBody struct {
+	io.Reader
+	body   io.Closer
+	cancel context.CancelFunc
+}
+
+func (b *streamBody) Close() error {
+	if c, ok := b.Reader.(io.Closer); ok {
+		_ = c.Close()
+	}
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
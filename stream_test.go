@@ -0,0 +1,28 @@
+package quick
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSession_SuckStream(t *testing.T) {
+	asserts := assert.New(t)
+
+	ser := RunCompressServer()
+	defer ser.Close()
+
+	req := NewRequest().SetUrl(ser.URL + "?enc=gzip,br")
+	resp, err := NewSession().SuckStream(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Close()
+
+	asserts.True(resp.Uncompressed)
+	asserts.Equal("", resp.GetHeaderSingle("Content-Encoding"))
+	body, err := ioutil.ReadAll(resp)
+	asserts.NoError(err)
+	asserts.Equal("quick", string(body))
+	asserts.NoError(resp.Close())
+}
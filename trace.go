@@ -51,10 +51,15 @@ type TraceInfo struct {
 
 	// RequestAttempt is to represent the request attempt made during a Quick
 	// request execution flow, including retry count.
+	// For hedged requests, it is the winning attempt, starting at 1.
 	RequestAttempt int
 
 	// RemoteAddr returns the remote network address.
 	RemoteAddr net.Addr
+
+	// RemoteAddrFamily is the address family of RemoteAddr:
+	// "ipv4", "ipv6" or "unix". Empty when there is no RemoteAddr.
+	RemoteAddrFamily string
 }
 
 func (t TraceInfo) String() string {
@@ -93,6 +98,34 @@ type clientTrace struct {
 	gotConnInfo          httptrace.GotConnInfo
 }
 
+// addrFamily returns the address family name of addr.
+func addrFamily(addr net.Addr) string {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	case *net.UnixAddr:
+		return "unix"
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return ""
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
 //‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
 // Trace unexported methods
 //_______________________________________________________________________
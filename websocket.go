@@ -0,0 +1,470 @@
+package quick
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrWebSocketHandshake is returned when the server refuses the WebSocket upgrade
+	ErrWebSocketHandshake = errors.New("websocket handshake failed")
+	// ErrWebSocketClosed is returned when writing to a closed WebSocket connection
+	ErrWebSocketClosed = errors.New("websocket connection closed")
+	// ErrWebSocketProtocol is returned when the server breaks the WebSocket protocol
+	ErrWebSocketProtocol = errors.New("websocket protocol error")
+	// ErrWebSocketMessageTooBig is returned when a message exceeds the read limit
+	ErrWebSocketMessageTooBig = errors.New("websocket message too big")
+)
+
+// WebSocket message types, see RFC 6455 section 11.8
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// WebSocket close codes, see RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	CloseMessageTooBig    = 1009
+)
+
+const (
+	// DefaultWebSocketReadLimit is the max size of a received message by default
+	DefaultWebSocketReadLimit = 32 << 20
+	// websocketCloseTimeout bounds the wait for the close reply of the server
+	websocketCloseTimeout = 5 * time.Second
+	// websocketReadChunk is the largest payload allocated at once before it is received
+	websocketReadChunk = 64 << 10
+	websocketGUID      = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// CloseError is returned by WebSocketConn.ReadMessage once the server closed the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket closed: " + strconv.Itoa(e.Code) + " " + e.Text
+}
+
+// WebSocketConn is a client WebSocket connection (RFC 6455), see Session.WebSocket.
+//
+// One goroutine may read and others write concurrently. Control frames are
+// handled while reading: pings are answered, pongs recorded, and a close
+// frame of the server is replied to.
+type WebSocketConn struct {
+	conn        io.ReadWriteCloser
+	br          *bufio.Reader
+	subprotocol string
+	cancel      context.CancelFunc
+
+	readMu    sync.Mutex
+	readLimit int64
+	readErr   error
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	lastPong      int64 // unix nano
+	closeReceived chan struct{}
+	closeOnce     sync.Once
+	connCloseOnce sync.Once
+}
+
+// WebSocket opens a WebSocket connection to rawurl, a ws, wss, http or https
+// URL, relative to the session BaseURL when set.
+//
+// The handshake request is sent by the session: it carries the session
+// headers and the cookies of its jar, goes through its proxies with its TLS
+// configuration, and honors the request options, e.g. OptionHeaderSingle to
+// ask for a subprotocol with Sec-WebSocket-Protocol. The request timeout
+// applies to the handshake, the connection is closed when the request
+// context ends. The handshake is sent over HTTP/1.1, even when HTTP/2 or
+// h2c is enabled.
+func (session *Session) WebSocket(rawurl string, ops ...OptionFunc) (*WebSocketConn, error) {
+	req := NewRequest().SetMethod(http.MethodGet).SetUrl(rawurl)
+	for _, option := range ops {
+		option(req)
+	}
+	key, err := websocketKey()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	resp, cancel, err := session.open(req, func(r *http.Request) {
+		// the session BaseURL may be a ws URL too
+		switch strings.ToLower(r.URL.Scheme) {
+		case "ws":
+			r.URL.Scheme = "http"
+		case "wss":
+			r.URL.Scheme = "https"
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, WrapErrf(ErrWebSocketHandshake, "unexpected response %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		_ = rwc.Close()
+		cancel()
+		return nil, WrapErr(ErrWebSocketHandshake, "invalid upgrade response headers")
+	}
+
+	c := &WebSocketConn{
+		conn:          rwc,
+		br:            bufio.NewReader(rwc),
+		subprotocol:   resp.Header.Get("Sec-WebSocket-Protocol"),
+		cancel:        cancel,
+		readLimit:     DefaultWebSocketReadLimit,
+		lastPong:      time.Now().UnixNano(),
+		closeReceived: make(chan struct{}),
+	}
+	// closeConn ends the request context too, the goroutine ends with the connection
+	go func(ctx context.Context) {
+		<-ctx.Done()
+		c.closeConn()
+	}(resp.Request.Context())
+	return c, nil
+}
+
+// Subprotocol returns the subprotocol selected by the server.
+func (c *WebSocketConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetReadLimit set the max size of a received message, 0 means no limit.
+// Default is DefaultWebSocketReadLimit.
+func (c *WebSocketConn) SetReadLimit(n int64) {
+	c.readMu.Lock()
+	c.readLimit = n
+	c.readMu.Unlock()
+}
+
+// ReadMessage reads the next data message, a TextMessage or a BinaryMessage.
+// Once the server closed the connection, a *CloseError is returned.
+func (c *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	messageType, data, err = c.readMessage()
+	if err != nil {
+		c.readErr = err
+	}
+	return messageType, data, err
+}
+
+// readMessage reads frames until a data message is complete.
+func (c *WebSocketConn) readMessage() (int, []byte, error) {
+	var (
+		messageType int
+		message     []byte
+	)
+	for {
+		fin, opcode, payload, err := c.readFrame(len(message))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, payload); err != nil && err != ErrWebSocketClosed {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			atomic.StoreInt64(&c.lastPong, time.Now().UnixNano())
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(payload)
+		case 0:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unfinished fragmented message")
+			}
+			messageType = opcode
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode "+strconv.Itoa(opcode))
+		}
+
+		message = append(message, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8 text message")
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// readFrame reads a frame, read bytes of the current message were already read.
+func (c *WebSocketConn) readFrame(read int) (fin bool, opcode int, payload []byte, err error) {
+	var header [8]byte
+	if _, err = io.ReadFull(c.br, header[:2]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if header[1]&0x80 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "masked server frame")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(c.br, header[:2]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, header[:8]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(header[:8])
+		if length > math.MaxInt64 {
+			return false, 0, nil, c.fail(CloseProtocolError, "invalid payload length")
+		}
+	}
+
+	if opcode >= CloseMessage {
+		if !fin || length > 125 {
+			return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+		}
+	} else if c.readLimit > 0 && length > uint64(c.readLimit)-uint64(read) {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "")
+	}
+
+	if length <= websocketReadChunk {
+		payload = make([]byte, length)
+		if _, err = io.ReadFull(c.br, payload); err != nil {
+			return false, 0, nil, err
+		}
+		return fin, opcode, payload, nil
+	}
+
+	// the buffer grows with the payload received, not its announced length
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, c.br, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, 0, nil, err
+	}
+	return fin, opcode, buf.Bytes(), nil
+}
+
+// handleClose replies to the close frame of the server.
+func (c *WebSocketConn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !utf8.ValidString(closeErr.Text) {
+			return c.fail(CloseInvalidPayload, "invalid close reason")
+		}
+	}
+	c.closeOnce.Do(func() { close(c.closeReceived) })
+
+	// echo the status code, then the server closes the TCP connection
+	var reply []byte
+	if closeErr.Code != CloseNoStatusReceived {
+		reply = payload[:2]
+	}
+	_ = c.writeFrame(CloseMessage, reply)
+	c.closeConn()
+	return closeErr
+}
+
+// fail closes the connection after a protocol violation of the server.
+func (c *WebSocketConn) fail(code int, reason string) error {
+	_ = c.writeFrame(CloseMessage, closePayload(code, reason))
+	c.closeConn()
+	if code == CloseMessageTooBig {
+		return ErrWebSocketMessageTooBig
+	}
+	return WrapErr(ErrWebSocketProtocol, reason)
+}
+
+// WriteMessage writes a message of messageType, TextMessage or BinaryMessage.
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return WrapErrf(ErrWebSocketProtocol, "invalid message type %d", messageType)
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// Ping sends a ping, the pong of the server is recorded by ReadMessage.
+func (c *WebSocketConn) Ping(data []byte) error {
+	return c.writeFrame(PingMessage, data)
+}
+
+// KeepAlive sends a ping every interval, and closes the connection when no
+// pong was received for interval plus timeout. Pongs are handled by
+// ReadMessage, so the connection must be read.
+func (c *WebSocketConn) KeepAlive(interval, timeout time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closeReceived:
+				return
+			case <-ticker.C:
+			}
+			lastPong := time.Unix(0, atomic.LoadInt64(&c.lastPong))
+			if time.Since(lastPong) > interval+timeout {
+				c.closeConn()
+				return
+			}
+			if err := c.Ping(nil); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// Close closes the connection with CloseNormalClosure, see CloseWithReason.
+func (c *WebSocketConn) Close() error {
+	return c.CloseWithReason(CloseNormalClosure, "")
+}
+
+// CloseWithReason sends a close frame, waits for the close frame of the
+// server, at most a few seconds, and closes the connection.
+func (c *WebSocketConn) CloseWithReason(code int, reason string) error {
+	err := c.writeFrame(CloseMessage, closePayload(code, reason))
+	if err == ErrWebSocketClosed {
+		c.closeConn()
+		return nil
+	}
+
+	timer := time.AfterFunc(websocketCloseTimeout, c.closeConn)
+	defer timer.Stop()
+	if c.readMu.TryLock() {
+		// nobody reads: wait for the close frame here
+		for c.readErr == nil {
+			_, _, c.readErr = c.readMessage()
+		}
+		c.readMu.Unlock()
+	} else {
+		// the reader gets the close frame, or the timer closes the connection
+		<-c.closeReceived
+	}
+	c.closeConn()
+	return err
+}
+
+// writeFrame writes a single masked frame.
+func (c *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrWebSocketClosed
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(n))
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// closeConn closes the network connection.
+func (c *WebSocketConn) closeConn() {
+	c.connCloseOnce.Do(func() {
+		c.closeOnce.Do(func() { close(c.closeReceived) })
+		_ = c.conn.Close()
+		c.cancel()
+	})
+}
+
+// closePayload returns the payload of a close frame.
+func closePayload(code int, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return append(payload, reason...)
+}
+
+// websocketKey returns a random Sec-WebSocket-Key.
+func websocketKey() (string, error) {
+	var key [16]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key[:]), nil
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept of key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// websocketUpgrade reports whether req is a WebSocket handshake.
+func websocketUpgrade(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
@@ -0,0 +1,284 @@
+package quick
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeServerFrame writes an unmasked frame.
+func writeServerFrame(w io.Writer, fin bool, opcode int, payload []byte) error {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0, byte(len(payload))}
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// readClientFrame reads a masked frame of at most 125 bytes.
+func readClientFrame(r io.Reader) (int, []byte, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, header[1]&0x7f)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= header[2+i%4]
+	}
+	return int(header[0] & 0x0f), payload, nil
+}
+
+// RunWebSocketServer is a WebSocket echo server. It pings the client,
+// sends a fragmented greeting, echoes messages and replies to close
+// frames, then closes the connection after a "bye" message.
+func RunWebSocketServer(pongs chan<- string) *httptest.Server {
+	return httptest.NewServer(webSocketHandler(pongs))
+}
+
+func webSocketHandler(pongs chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Protocol: " + r.Header.Get("Sec-WebSocket-Protocol") + "\r\n" +
+			"Sec-WebSocket-Accept: " + websocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		_ = writeServerFrame(rw, true, PingMessage, []byte("ping"))
+		_ = writeServerFrame(rw, false, TextMessage, []byte("hello "))
+		_ = writeServerFrame(rw, true, 0, []byte(r.Header.Get("X-User")))
+		_ = rw.Flush()
+
+		reader := bufio.NewReader(rw)
+		for {
+			opcode, payload, err := readClientFrame(reader)
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case PongMessage:
+				pongs <- string(payload)
+				continue
+			case PingMessage:
+				_ = writeServerFrame(rw, true, PongMessage, payload)
+			case CloseMessage:
+				_ = writeServerFrame(rw, true, CloseMessage, payload)
+				_ = rw.Flush()
+				return
+			case TextMessage, BinaryMessage:
+				if string(payload) == "bye" {
+					_ = writeServerFrame(rw, true, CloseMessage, closePayload(CloseGoingAway, "bye"))
+				} else {
+					_ = writeServerFrame(rw, true, opcode, payload)
+				}
+			}
+			_ = rw.Flush()
+		}
+	})
+}
+
+func TestSession_WebSocket(t *testing.T) {
+	asserts := assert.New(t)
+
+	pongs := make(chan string, 1)
+	srv := RunWebSocketServer(pongs)
+	defer srv.Close()
+
+	session := NewSession()
+	session.BaseURL = "ws" + strings.TrimPrefix(srv.URL, "http")
+	session.SetHeaderSingle("X-User", "quick")
+
+	conn, err := session.WebSocket("/chat", OptionHeaderSingle("Sec-WebSocket-Protocol", "chat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.Equal("chat", conn.Subprotocol())
+
+	// fragmented message, the ping is answered meanwhile
+	messageType, data, err := conn.ReadMessage()
+	asserts.NoError(err)
+	asserts.Equal(TextMessage, messageType)
+	asserts.Equal("hello quick", string(data))
+	asserts.Equal("ping", <-pongs)
+
+	asserts.NoError(conn.WriteMessage(BinaryMessage, []byte{1, 2, 3}))
+	messageType, data, err = conn.ReadMessage()
+	asserts.NoError(err)
+	asserts.Equal(BinaryMessage, messageType)
+	asserts.Equal([]byte{1, 2, 3}, data)
+
+	// pongs are recorded while reading
+	before := atomic.LoadInt64(&conn.lastPong)
+	asserts.NoError(conn.Ping([]byte("p")))
+	asserts.NoError(conn.WriteMessage(TextMessage, []byte("echo")))
+	_, data, _ = conn.ReadMessage()
+	asserts.Equal("echo", string(data))
+	asserts.True(atomic.LoadInt64(&conn.lastPong) > before)
+
+	// closed by the server
+	asserts.NoError(conn.WriteMessage(TextMessage, []byte("bye")))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	if asserts.True(errors.As(err, &closeErr)) {
+		asserts.Equal(CloseGoingAway, closeErr.Code)
+		asserts.Equal("bye", closeErr.Text)
+	}
+	asserts.Equal(ErrWebSocketClosed, conn.WriteMessage(TextMessage, []byte("late")))
+	asserts.NoError(conn.Close())
+}
+
+func TestSession_WebSocket_HTTP2(t *testing.T) {
+	asserts := assert.New(t)
+
+	pongs := make(chan string, 2)
+	srv := httptest.NewUnstartedServer(webSocketHandler(pongs))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	opts := DefaultSessionOptions()
+	opts.EnableHTTP2 = true
+	session := NewSession(opts).InsecureSkipVerify(true)
+
+	// the handshake is sent over HTTP/1.1, even next to an HTTP/2 connection
+	resp, err := session.Get(srv.URL)
+	if asserts.NoError(err) {
+		asserts.Equal("HTTP/2.0", resp.Proto)
+	}
+	conn, err := session.WebSocket("wss" + strings.TrimPrefix(srv.URL, "https"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := conn.ReadMessage()
+	asserts.NoError(err)
+	asserts.Equal("hello ", string(data))
+	asserts.NoError(conn.Close())
+
+	// ws URLs are not sent as h2c
+	h2cSrv := httptest.NewServer(h2c.NewHandler(webSocketHandler(pongs), &http2.Server{}))
+	defer h2cSrv.Close()
+	opts = DefaultSessionOptions()
+	opts.EnableH2C = true
+	conn, err = NewSession(opts).WebSocket("ws" + strings.TrimPrefix(h2cSrv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err = conn.ReadMessage()
+	asserts.NoError(err)
+	asserts.Equal("hello ", string(data))
+	asserts.NoError(conn.Close())
+}
+
+func TestSession_WebSocket_Close(t *testing.T) {
+	asserts := assert.New(t)
+
+	pongs := make(chan string, 1)
+	srv := RunWebSocketServer(pongs)
+	defer srv.Close()
+
+	conn, err := NewSession().WebSocket(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _ = conn.ReadMessage()
+
+	// close handshake without a reader
+	start := time.Now()
+	asserts.NoError(conn.Close())
+	asserts.True(time.Since(start) < websocketCloseTimeout)
+	var closeErr *CloseError
+	_, _, err = conn.ReadMessage()
+	asserts.True(errors.As(err, &closeErr))
+
+	// not a websocket endpoint
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	_, err = NewSession().WebSocket(plain.URL)
+	asserts.True(errors.Is(err, ErrWebSocketHandshake))
+}
+
+func TestSession_WebSocket_Cache(t *testing.T) {
+	asserts := assert.New(t)
+
+	pongs := make(chan string, 1)
+	ws := webSocketHandler(pongs)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"page"`)
+			return
+		}
+		ws.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// the handshake is not answered by the cached page
+	session := NewSession().SetCache(NewMemoryCache(0)).EnableDedup()
+	_, err := session.Get(srv.URL)
+	asserts.NoError(err)
+	conn, err := session.WebSocket("ws" + strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.NoError(conn.Close())
+}
+
+// frameConn reads the frames of a server and discards the frames written.
+type frameConn struct {
+	io.Reader
+}
+
+func (frameConn) Write(p []byte) (int, error) { return len(p), nil }
+func (frameConn) Close() error                { return nil }
+
+func TestWebSocketConn_Frames(t *testing.T) {
+	asserts := assert.New(t)
+
+	newConn := func(frame []byte) *WebSocketConn {
+		rwc := frameConn{bytes.NewReader(frame)}
+		return &WebSocketConn{
+			conn:          rwc,
+			br:            bufio.NewReader(rwc),
+			cancel:        func() {},
+			closeReceived: make(chan struct{}),
+		}
+	}
+
+	// a 64-bit length must not have its most significant bit set
+	_, _, err := newConn([]byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 1}).ReadMessage()
+	asserts.ErrorIs(err, ErrWebSocketProtocol)
+
+	// without read limit, a huge length is not allocated ahead of the payload
+	_, _, err = newConn([]byte{0x82, 127, 0x40, 0, 0, 0, 0, 0, 0, 0, 'x'}).ReadMessage()
+	asserts.Equal(io.ErrUnexpectedEOF, err)
+
+	payload := closePayload(CloseNormalClosure, "done")
+	asserts.Equal(uint16(CloseNormalClosure), binary.BigEndian.Uint16(payload))
+	asserts.Equal("done", string(payload[2:]))
+
+	// RFC 6455 section 1.3
+	asserts.Equal("s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}